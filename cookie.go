@@ -0,0 +1,113 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"strings"
+)
+
+// Return headers with cookie header fields split into crumbs at "; ".
+// If there is nothing to split, headers is returned as is.
+func splitCookies(headers []*Header) []*Header {
+	var split []*Header
+
+	for i, header := range headers {
+		if !ctstreq(header.Name, "cookie") ||
+			!strings.Contains(header.Value, "; ") {
+			if split != nil {
+				split = append(split, header)
+			}
+
+			continue
+		}
+
+		if split == nil {
+			split = append(split, headers[:i]...)
+		}
+
+		for _, crumb := range strings.Split(header.Value, "; ") {
+			h := *header
+			h.Value = crumb
+			split = append(split, &h)
+		}
+	}
+
+	if split == nil {
+		return headers
+	}
+
+	return split
+}
+
+// JoinCookies returns headers with all cookie header fields joined
+// into one, delimited by "; ", as required when passing them to
+// HTTP/1.1 or non-HTTP/2 context (RFC 7540 Section 8.1.2.5).  The
+// joined header field is placed at the position of the first cookie
+// header field, and it is never indexed if any of them is.  If
+// there are less than 2 cookie header fields, headers is returned as
+// is.
+func JoinCookies(headers []Header) []Header {
+	first := -1
+	n := 0
+
+	for i := range headers {
+		if headers[i].Name == "cookie" {
+			if first == -1 {
+				first = i
+			}
+
+			n++
+		}
+	}
+
+	if n < 2 {
+		return headers
+	}
+
+	joined := make([]Header, 0, len(headers)-n+1)
+	crumbs := make([]string, 0, n)
+	neverIndex := false
+
+	for i := range headers {
+		if headers[i].Name != "cookie" {
+			joined = append(joined, headers[i])
+			continue
+		}
+
+		crumbs = append(crumbs, headers[i].Value)
+		neverIndex = neverIndex || headers[i].NeverIndex
+
+		if i == first {
+			joined = append(joined, Header{})
+		}
+	}
+
+	joined[first] = Header{
+		Name:       "cookie",
+		Value:      strings.Join(crumbs, "; "),
+		NeverIndex: neverIndex,
+	}
+
+	return joined
+}
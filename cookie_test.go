@@ -0,0 +1,115 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestEncoderSplitCookies(t *testing.T) {
+	enc := NewEncoderWithOptions(EncoderOptions{
+		MaxTableSize: DEFAULT_HEADER_TABLE_SIZE,
+		SplitCookies: true,
+	})
+	dec := NewDecoder()
+
+	nva := []*Header{
+		NewHeader(":method", "GET", false),
+		NewHeader("cookie", "a=b; c=d; e=f", false),
+		NewHeader("cookie", "g=h", false),
+	}
+
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, nva)
+
+	headers, err := dec.DecodeFull(encoded.Bytes())
+
+	if err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	expected := []Header{
+		{Name: ":method", Value: "GET"},
+		{Name: "cookie", Value: "a=b"},
+		{Name: "cookie", Value: "c=d"},
+		{Name: "cookie", Value: "e=f"},
+		{Name: "cookie", Value: "g=h"},
+	}
+
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("headers = %v, want %v", headers, expected)
+	}
+
+	// Each crumb is indexed individually.
+	if enc.ht.tablelen != 4 {
+		t.Errorf("enc.ht.tablelen = %v, want %v", enc.ht.tablelen, 4)
+	}
+
+	// The caller's header fields are not modified.
+	if nva[1].Value != "a=b; c=d; e=f" {
+		t.Errorf("nva[1].Value = %v, want %v", nva[1].Value,
+			"a=b; c=d; e=f")
+	}
+
+	// The maximum header list size applies to the crumbs: 3 * (6 +
+	// 3 + 32) = 123.
+	enc.SetMaxHeaderListSize(122)
+
+	err = enc.Encode(encoded, []*Header{nva[1]})
+
+	if err != ErrHeaderListTooLarge {
+		t.Errorf("enc.Encode(...) returned %v, want %v",
+			err, ErrHeaderListTooLarge)
+	}
+}
+
+func TestJoinCookies(t *testing.T) {
+	headers := []Header{
+		{Name: ":method", Value: "GET"},
+		{Name: "cookie", Value: "a=b"},
+		{Name: "alpha", Value: "bravo"},
+		{Name: "cookie", Value: "c=d", NeverIndex: true},
+		{Name: "cookie", Value: "e=f"},
+	}
+
+	expected := []Header{
+		{Name: ":method", Value: "GET"},
+		{Name: "cookie", Value: "a=b; c=d; e=f", NeverIndex: true},
+		{Name: "alpha", Value: "bravo"},
+	}
+
+	if joined := JoinCookies(headers); !reflect.DeepEqual(joined, expected) {
+		t.Errorf("JoinCookies(...) = %v, want %v", joined, expected)
+	}
+
+	// Nothing to join
+	headers = headers[:2]
+
+	if joined := JoinCookies(headers); !reflect.DeepEqual(joined, headers) {
+		t.Errorf("JoinCookies(...) = %v, want %v", joined, headers)
+	}
+}
@@ -26,6 +26,7 @@ package hpack
 import (
 	"bytes"
 	"fmt"
+	"unsafe"
 )
 
 // A Decoder decodes HPACK encoded byte string in streaming fashion.
@@ -37,9 +38,11 @@ type Decoder struct {
 	hdec  *HuffmanDecoder
 	// Pointer to header table entry for indexed name.
 	entName *headerTableEntry
+	// Index of header field decoded by indexed representation.
+	index int
 	// Opcode for HPACK encoding; initially opcodeNone.  Input
-	// contains opcode one of opcodeIndexed, opcodeNewname and
-	// opcodeIndname.
+	// contains opcode one of opcodeIndexed, opcodeNewname,
+	// opcodeIndname and opcodeTableSize.
 	opcode int
 	// Current decoding state
 	state int
@@ -60,8 +63,52 @@ type Decoder struct {
 	// true if encoder requires that the current name/value pair
 	// must never be indexed.
 	neverIndex bool
-	// true if decoder encountered error.
-	fail bool
+	// The error decoder encountered.  Once it is set, decoder
+	// cannot process any input.
+	err error
+	// true if at least one header field representation has been
+	// decoded in the current header block.
+	fieldSeen bool
+	// The revision of HPACK specification this decoder follows.
+	spec Spec
+	// The size of header list decoded so far in the current header
+	// block.
+	headerListSize uint
+	// Maximum header list size set by SetMaxHeaderListSize().
+	maxHeaderListSize uint
+	// Minimum header table size set by ChangeTableSize() which is
+	// smaller than the header table size in use.  The encoder must
+	// send dynamic table size update which is less than or equal to
+	// this value at the beginning of the next header block.
+	// uint32Max if no such update is required.
+	settingsMinTableSize uint
+	// The number of dynamic table size updates in the current
+	// header block.
+	tableSizeUpdates int
+	// The number of bytes processed in the current header block
+	// before the current call of Decode().
+	blockOffset int
+	// Validator set by SetValidator(), or nil.
+	validator *Validator
+	// true if validator found that the current header block is
+	// malformed.
+	malformed bool
+	// Function set by SetEmitFunc() which receives header fields
+	// decoded by Write().
+	emit func(Header) error
+	// true if the header list in the current header block exceeds
+	// the maximum header list size.  The rest of header block is
+	// decoded to keep the compression context in sync, but header
+	// fields are no longer passed to the caller.
+	tooLarge bool
+	// true if *HeaderListTooLargeError has been returned for the
+	// current header block.
+	tooLargeReported bool
+	// The number of octets of name and value of the current header
+	// field which were discarded because the header list is too
+	// large and the header field is not inserted into the header
+	// table.
+	discarded uint
 }
 
 const (
@@ -69,6 +116,7 @@ const (
 	opcodeIndexed
 	opcodeNewname
 	opcodeIndname
+	opcodeTableSize
 )
 
 const (
@@ -85,18 +133,54 @@ const (
 	stateReadValue
 )
 
-// NewDecoder returns new HPACK decoder.
+// NewDecoder returns new HPACK decoder which follows RFC 7541.
 func NewDecoder() *Decoder {
+	return NewDecoderSpec(RFC7541)
+}
+
+// NewDecoderSpec returns new HPACK decoder which follows the given
+// revision of HPACK specification.
+func NewDecoderSpec(spec Spec) *Decoder {
 	ht := newHeaderTable(DEFAULT_HEADER_TABLE_SIZE)
 	nvbuf := &bytes.Buffer{}
 	hdec := NewHuffmanDecoder()
 
-	d := &Decoder{ht, nvbuf, hdec, nil,
-		opcodeNone, stateOpcode, 0, 0, 0, DEFAULT_HEADER_TABLE_SIZE,
-		false, false, false, false}
+	d := &Decoder{
+		ht:                   ht,
+		nvbuf:                nvbuf,
+		hdec:                 hdec,
+		opcode:               opcodeNone,
+		state:                stateOpcode,
+		settingsMaxTableSize: DEFAULT_HEADER_TABLE_SIZE,
+		spec:                 spec,
+		maxHeaderListSize:    uintMax,
+		settingsMinTableSize: uint32Max,
+	}
 	return d
 }
 
+// Set the maximum header list size to n.  This is the value of
+// SETTINGS_MAX_HEADER_LIST_SIZE we advertised.  If the size of header
+// list in a header block exceeds n, Decode returns
+// *HeaderListTooLargeError.  Like *MalformedError, it only fails the
+// header block, and the decoder can be used for the next header
+// block.  By default, there is no limit.
+func (dec *Decoder) SetMaxHeaderListSize(n uint) {
+	dec.maxHeaderListSize = n
+}
+
+// Set validator which checks decoded header fields against HTTP/2
+// semantic rules.  If v is nil, header fields are not validated.  v
+// keeps the state of the header block being decoded, so that it must
+// not be shared with other Decoders.
+func (dec *Decoder) SetValidator(v *Validator) {
+	dec.validator = v
+
+	if v != nil {
+		v.Reset()
+	}
+}
+
 // Return the sum of header name and value length currently being
 // decoded.
 func (dec *Decoder) DecodingHeaderSize() int {
@@ -107,42 +191,306 @@ func (dec *Decoder) DecodingHeaderSize() int {
 // that this is the end of complete compressed header block.  It helps
 // decoder to check prematured end of encoded sequence.  This function
 // returns header field if it is decoded and the number of bytes
-// processed so far.  This function returns whenever one header field
-// was decoded.  The caller must repeatedly call this function until
-// whole input is processed, for example, by updating src slice.  Once
-// this function returns error, further call of this function shall
-// fail.
+// processed so far.  The returned header field is owned by the
+// caller, and modifying it does not affect the header table.  This
+// function returns whenever one header field was decoded.  The caller
+// must repeatedly call this function until whole input is processed,
+// for example, by updating src slice.  Once this function returns
+// error, further call of this function shall fail.  The error
+// returned is *DecodingError, except for *MalformedError which is
+// returned if validator is set and it found header list malformed,
+// and *HeaderListTooLargeError.  In that case, the caller should
+// continue decoding the rest of header block to keep the compression
+// context in sync, and reset the stream.  The rest of header fields
+// in the header block are not validated.  If the header list is too
+// large, the rest of header fields are not returned either.
 func (dec *Decoder) Decode(src []byte, final bool) (*Header, int, error) {
-	cur := 0
+	ready, nread, err := dec.next(src, final)
+
+	if err != nil {
+		return nil, nread, err
+	}
+
+	var header *Header
+
+	if ready {
+		header = dec.emitHeader()
+
+		if dec.tooLarge {
+			header = nil
+		} else {
+			err = dec.validateField(header)
+		}
+	}
+
+	err = dec.endStep(final && nread == len(src), nread, err)
+
+	if err != nil {
+		return nil, nread, err
+	}
+
+	return header, nread, nil
+}
+
+// DecodeFull decodes block, which must be a complete compressed
+// header block, and returns the header fields in it.  The errors are
+// the same as Decode.  If *MalformedError is returned, block was
+// decoded to the end to keep the compression context in sync, and
+// the returned header fields are the ones decoded before it was
+// found malformed.  The same goes for *HeaderListTooLargeError.
+func (dec *Decoder) DecodeFull(block []byte) ([]Header, error) {
+	var headers []Header
+
+	err := dec.DecodeFunc(block, true, func(header Header) error {
+		headers = append(headers, header)
+		return nil
+	})
+
+	return headers, err
+}
+
+// Set the function which receives header fields decoded by Write.
+// If emit is nil, header fields are discarded.
+func (dec *Decoder) SetEmitFunc(emit func(Header) error) {
+	dec.emit = emit
+}
+
+// Write decodes p, which is a part of compressed header block, and
+// passes decoded header fields to the function set by SetEmitFunc.
+// This is equivalent to DecodeFunc(p, false, emit).  All of p is
+// processed unless error occurs.  If *MalformedError or
+// *HeaderListTooLargeError is returned, all of p was processed, and
+// this function returns len(p), so that p must not be written again.
+// For other errors, it returns 0.
+func (dec *Decoder) Write(p []byte) (int, error) {
+	if err := dec.DecodeFunc(p, false, dec.emit); err != nil {
+		// dec.err is set if the decoder cannot continue.
+		if dec.err != nil {
+			return 0, err
+		}
 
-	if dec.fail {
-		return nil, cur, fmt.Errorf("could not process any input due to earlier error")
+		return len(p), err
 	}
 
+	return len(p), nil
+}
+
+// Close signals the decoder that the current header block ends.  It
+// returns error if the header block ended prematurely.  The decoder
+// can be used for the next header block after this call.
+func (dec *Decoder) Close() error {
+	return dec.DecodeFunc(nil, true, dec.emit)
+}
+
+// DecodeFunc decodes all of src and passes each decoded header field
+// to emit.  Unlike Decode, this function does not return until whole
+// src is processed.  The final signals the decoder that this is the
+// end of complete compressed header block, like Decode.  A header
+// field may be split across the calls with final false, for example,
+// HEADERS and following CONTINUATION frames.  If emit returns error,
+// decoding stops and the error is returned, and further call of this
+// function fails since the compression context is no longer in sync.
+// Other errors are the same as Decode.  If *MalformedError or
+// *HeaderListTooLargeError is found, the rest of src is decoded, but
+// header fields are no longer passed to emit until the end of header
+// block.
+func (dec *Decoder) DecodeFunc(src []byte, final bool, emit func(Header) error) error {
+	return dec.decodeAll(src, final, func() error {
+		header := dec.emitHeader()
+
+		if dec.tooLarge {
+			return nil
+		}
+
+		if err := dec.validateField(header); err != nil || dec.malformed {
+			return err
+		}
+
+		if emit != nil {
+			if err := emit(*header); err != nil {
+				dec.err = err
+			}
+		}
+
+		return nil
+	})
+}
+
+// DecodeBytesFunc is like DecodeFunc, but passes header field name
+// and value to emit as byte slices without allocating them.  The
+// slices point to the internal buffer of the decoder or to the header
+// table, and they are only valid until emit returns.  emit must not
+// modify them.  If emit wants to keep them, it must copy them.  If
+// validator is set, header field is copied for validation.
+func (dec *Decoder) DecodeBytesFunc(src []byte, final bool, emit func(name, value []byte, neverIndex bool) error) error {
+	return dec.decodeAll(src, final, func() error {
+		return dec.emitBytes(func(name, value []byte, neverIndex bool) error {
+			if dec.tooLarge {
+				return nil
+			}
+
+			if dec.validator != nil && !dec.malformed {
+				header := &Header{Name: string(name),
+					Value: string(value), NeverIndex: neverIndex}
+
+				if err := dec.validateField(header); err != nil {
+					return err
+				}
+			}
+
+			if dec.malformed {
+				return nil
+			}
+
+			if err := emit(name, value, neverIndex); err != nil {
+				dec.err = err
+			}
+
+			return nil
+		})
+	})
+}
+
+// Decode all of src.  Whenever a header field is decoded, field is
+// called to pass it to the caller.  field returns *MalformedError if
+// validator found the header field malformed, and sets dec.err to
+// stop decoding.  *MalformedError and *HeaderListTooLargeError are
+// returned after all of src is decoded.
+func (dec *Decoder) decodeAll(src []byte, final bool, field func() error) error {
+	var streamErr error
+
+	for {
+		ready, nread, err := dec.next(src, final)
+
+		if err != nil {
+			return err
+		}
+
+		src = src[nread:]
+
+		if ready {
+			err = field()
+
+			if dec.err != nil {
+				return dec.err
+			}
+		}
+
+		err = dec.endStep(final && len(src) == 0, nread, err)
+
+		if err != nil && streamErr == nil {
+			streamErr = err
+		}
+
+		if len(src) == 0 {
+			return streamErr
+		}
+	}
+}
+
+// Decode src until one header field is decoded.  This function
+// returns true if header field was decoded, and the number of bytes
+// processed.  The header field must be emitted before the next call.
+func (dec *Decoder) next(src []byte, final bool) (bool, int, error) {
+	if dec.err != nil {
+		return false, 0, dec.err
+	}
+
+	ready, nread, err := dec.decode(src, final)
+
+	if err != nil {
+		dec.err = &DecodingError{
+			Offset:      dec.blockOffset + nread,
+			Instruction: instructionOf(dec.opcode),
+			State:       stateNames[dec.state],
+			Err:         err,
+		}
+
+		return false, nread, dec.err
+	}
+
+	return ready, nread, nil
+}
+
+// Validate header if validator is set and the current header block
+// has not been found malformed.
+func (dec *Decoder) validateField(header *Header) error {
+	if dec.validator == nil || dec.malformed || dec.tooLarge {
+		return nil
+	}
+
+	return dec.validator.Field(header)
+}
+
+// Called after each call of next().  end is true if the current
+// header block ends.  err is the error validator found, and this
+// function returns it, *HeaderListTooLargeError if the header list
+// has just become too large, or the error found at the end of header
+// block.
+func (dec *Decoder) endStep(end bool, nread int, err error) error {
+	if dec.tooLarge && !dec.tooLargeReported {
+		dec.tooLargeReported = true
+
+		if err == nil {
+			err = &HeaderListTooLargeError{dec.maxHeaderListSize}
+		}
+	}
+
+	if end {
+		if err == nil && dec.validator != nil && !dec.malformed &&
+			!dec.tooLarge {
+			err = dec.validator.End()
+		}
+
+		dec.endBlock()
+	} else {
+		if err != nil {
+			dec.malformed = true
+		}
+
+		dec.blockOffset += nread
+	}
+
+	return err
+}
+
+func (dec *Decoder) decode(src []byte, final bool) (bool, int, error) {
+	cur := 0
+
 	for cur < len(src) {
 		switch dec.state {
 		case stateOpcode:
 			c := src[cur]
+
 			switch {
 			case (c & 0xe0) == 0x20:
-				dec.opcode = opcodeIndexed
-				dec.state = stateReadTableSize
+				dec.opcode = opcodeTableSize
 			case (c & 0x80) != 0:
 				dec.opcode = opcodeIndexed
+			case c == 0x40 || c == 0 || c == 0x10:
+				dec.opcode = opcodeNewname
+			default:
+				dec.opcode = opcodeIndname
+			}
+
+			if err := dec.checkBlockStart(); err != nil {
+				return false, cur, err
+			}
+
+			switch dec.opcode {
+			case opcodeTableSize:
+				dec.state = stateReadTableSize
+			case opcodeIndexed:
 				dec.state = stateReadIndex
 			default:
-				if c == 0x40 || c == 0 || c == 0x10 {
-					dec.opcode = opcodeNewname
-					dec.state = stateCheckNamelen
-				} else {
-					dec.opcode = opcodeIndname
-					dec.state = stateReadIndex
-				}
 				dec.indexRequired = (c & 0x40) != 0
 				dec.neverIndex = (c & 0xf0) == 0x10
 
 				if dec.opcode == opcodeNewname {
+					dec.state = stateCheckNamelen
 					cur++
+				} else {
+					dec.state = stateReadIndex
 				}
 			}
 
@@ -153,8 +501,7 @@ func (dec *Decoder) Decode(src []byte, final bool) (*Header, int, error) {
 				readInt(src[cur:], dec.left, dec.shift, 5)
 
 			if err != nil {
-				dec.fail = true
-				return nil, cur, err
+				return false, cur, err
 			}
 
 			cur += nread
@@ -162,14 +509,17 @@ func (dec *Decoder) Decode(src []byte, final bool) (*Header, int, error) {
 			dec.shift = shift
 
 			if size > dec.settingsMaxTableSize {
-				dec.fail = true
-				return nil, cur, fmt.Errorf(
-					"header table size is too large %v > %v",
+				return false, cur, fmt.Errorf("%w: %v > %v",
+					ErrTableSizeTooLarge,
 					size, dec.settingsMaxTableSize)
 			}
 
 			if !sizefin {
-				return nil, cur, dec.almostOK(final)
+				return false, cur, dec.almostOK(final)
+			}
+
+			if size <= dec.settingsMinTableSize {
+				dec.settingsMinTableSize = uint32Max
 			}
 
 			dec.ht.ChangeTableSize(size)
@@ -192,8 +542,7 @@ func (dec *Decoder) Decode(src []byte, final bool) (*Header, int, error) {
 					prefixlen)
 
 			if err != nil {
-				dec.fail = true
-				return nil, cur, err
+				return false, cur, err
 			}
 
 			cur += nread
@@ -201,31 +550,30 @@ func (dec *Decoder) Decode(src []byte, final bool) (*Header, int, error) {
 			dec.shift = shift
 
 			if index > uint(dec.maxIndex()+1) {
-				dec.fail = true
-				return nil, cur, fmt.Errorf(
-					"index is too large %v > %v",
+				return false, cur, fmt.Errorf("%w: %v > %v",
+					ErrIndexTooLarge,
 					index, dec.maxIndex()+1)
 			}
 
 			if !indexfin {
-				return nil, cur, dec.almostOK(final)
+				return false, cur, dec.almostOK(final)
 			}
 
 			if index == 0 {
-				dec.fail = true
-				return nil, cur, fmt.Errorf("illegal index = 0")
+				return false, cur, ErrIndexZero
 			}
 
 			index--
 
 			if dec.opcode == opcodeIndexed {
-				header := dec.emitIndexed(int(index))
+				dec.index = int(index)
+
+				dec.addHeaderListSize(&dec.ht.Get(dec.index).header)
 
 				dec.state = stateOpcode
 
-				if header != nil {
-					return header, cur, nil
-				}
+				return true, cur,
+					dec.almostOK(final && cur == len(src))
 			} else {
 				dec.entName = dec.ht.Get(int(index))
 				dec.state = stateCheckValuelen
@@ -241,8 +589,7 @@ func (dec *Decoder) Decode(src []byte, final bool) (*Header, int, error) {
 				readInt(src[cur:], dec.left, dec.shift, 7)
 
 			if err != nil {
-				dec.fail = true
-				return nil, cur, err
+				return false, cur, err
 			}
 
 			cur += nread
@@ -250,43 +597,42 @@ func (dec *Decoder) Decode(src []byte, final bool) (*Header, int, error) {
 			dec.shift = shift
 
 			if !lengthfin {
-				return nil, cur, dec.almostOK(final)
+				return false, cur, dec.almostOK(final)
 			}
 
 			if dec.huffmanEncoded {
 				dec.hdec.Reset()
 				dec.state = stateReadNamehuff
 			} else {
+				dec.checkHeaderListSize(dec.left)
+
 				dec.state = stateReadName
 			}
 		case stateReadNamehuff:
-			nread, err :=
-				readHuffman(dec.hdec, dec.nvbuf,
-					src[cur:], int(dec.left))
+			nread, err := dec.readHuffman(src[cur:])
 
 			if err != nil {
-				dec.fail = true
-				return nil, cur, err
+				return false, cur, err
 			}
 
 			cur += nread
 			dec.left -= uint(nread)
 
 			if dec.left > 0 {
-				return nil, cur, nil
+				return false, cur, dec.almostOK(final)
 			}
 
 			dec.newnamelen = dec.nvbuf.Len()
 			dec.state = stateCheckValuelen
 
 		case stateReadName:
-			nread := readString(dec.nvbuf, src[cur:], int(dec.left))
+			nread := dec.readString(src[cur:])
 
 			cur += nread
 			dec.left -= uint(nread)
 
 			if dec.left > 0 {
-				return nil, cur, nil
+				return false, cur, dec.almostOK(final)
 			}
 
 			dec.newnamelen = dec.nvbuf.Len()
@@ -302,8 +648,7 @@ func (dec *Decoder) Decode(src []byte, final bool) (*Header, int, error) {
 				readInt(src[cur:], dec.left, dec.shift, 7)
 
 			if err != nil {
-				dec.fail = true
-				return nil, cur, err
+				return false, cur, err
 			}
 
 			cur += nread
@@ -311,20 +656,22 @@ func (dec *Decoder) Decode(src []byte, final bool) (*Header, int, error) {
 			dec.shift = shift
 
 			if !lengthfin {
-				return nil, cur, dec.almostOK(final)
+				return false, cur, dec.almostOK(final)
 			}
 
-			var header *Header
+			n := dec.decodedLen()
 
-			if dec.left == 0 {
-				if dec.opcode == opcodeNewname {
-					header = dec.emitNewname()
-				} else {
-					header = dec.emitIndname()
-				}
+			if !dec.huffmanEncoded {
+				n += dec.left
+			}
 
+			dec.checkHeaderListSize(n)
+
+			if dec.left == 0 {
+				dec.headerListSize += dec.decodedLen() + headerEntryOverhead
 				dec.state = stateOpcode
-				return header, cur, nil
+				return true, cur,
+					dec.almostOK(final && cur == len(src))
 			}
 
 			if dec.huffmanEncoded {
@@ -334,80 +681,298 @@ func (dec *Decoder) Decode(src []byte, final bool) (*Header, int, error) {
 				dec.state = stateReadValue
 			}
 		case stateReadValuehuff:
-			nread, err :=
-				readHuffman(dec.hdec, dec.nvbuf,
-					src[cur:], int(dec.left))
+			nread, err := dec.readHuffman(src[cur:])
 
 			if err != nil {
-				dec.fail = true
-				return nil, cur, err
+				return false, cur, err
 			}
 
 			cur += nread
 			dec.left -= uint(nread)
 
 			if dec.left > 0 {
-				return nil, cur, dec.almostOK(final)
-			}
-
-			var header *Header
-
-			if dec.opcode == opcodeNewname {
-				header = dec.emitNewname()
-			} else {
-				header = dec.emitIndname()
+				return false, cur, dec.almostOK(final)
 			}
 
+			dec.headerListSize += dec.decodedLen() + headerEntryOverhead
 			dec.state = stateOpcode
 
-			return header, cur, nil
+			return true, cur,
+				dec.almostOK(final && cur == len(src))
 		case stateReadValue:
-			nread := readString(dec.nvbuf, src[cur:], int(dec.left))
+			nread := dec.readString(src[cur:])
 
 			cur += nread
 			dec.left -= uint(nread)
 
 			if dec.left > 0 {
-				return nil, cur, dec.almostOK(final)
-			}
-
-			var header *Header
-
-			if dec.opcode == opcodeNewname {
-				header = dec.emitNewname()
-			} else {
-				header = dec.emitIndname()
+				return false, cur, dec.almostOK(final)
 			}
 
+			dec.headerListSize += dec.decodedLen() + headerEntryOverhead
 			dec.state = stateOpcode
 
-			return header, cur, nil
+			return true, cur,
+				dec.almostOK(final && cur == len(src))
 		}
 	}
 
-	return nil, cur, dec.almostOK(final)
+	return false, cur, dec.almostOK(final)
 }
 
 // Decoding almost successful, but if final is true, we have to make
 // sure that current decoding state is right one.
 func (dec *Decoder) almostOK(final bool) error {
-	if final && dec.state != stateOpcode {
-		dec.fail = true
-		return fmt.Errorf("input ended prematurely")
+	if !final {
+		return nil
+	}
+
+	if dec.state != stateOpcode {
+		return ErrInputEndedPrematurely
+	}
+
+	return nil
+}
+
+// Called when the current header block ends.
+func (dec *Decoder) endBlock() {
+	dec.fieldSeen = false
+	dec.tableSizeUpdates = 0
+	dec.headerListSize = 0
+	dec.blockOffset = 0
+	dec.malformed = false
+	dec.tooLarge = false
+	dec.tooLargeReported = false
+
+	if dec.validator != nil {
+		dec.validator.Reset()
+	}
+}
+
+// Check that the representation of the current opcode is allowed at
+// this position of header block.  RFC 7541 allows at most two dynamic
+// table size updates only at the beginning of header block, and
+// requires one if maximum header table size was reduced.
+func (dec *Decoder) checkBlockStart() error {
+	if dec.spec == Draft09 {
+		return nil
+	}
+
+	switch {
+	case dec.opcode != opcodeTableSize:
+		if !dec.fieldSeen && dec.settingsMinTableSize != uint32Max {
+			return ErrTableSizeUpdateRequired
+		}
+
+		dec.fieldSeen = true
+	case dec.fieldSeen:
+		return ErrTableSizeUpdateAfterHeader
+	case dec.tableSizeUpdates == 2:
+		return ErrTooManyTableSizeUpdates
+	default:
+		dec.tableSizeUpdates++
 	}
 
 	return nil
 }
 
-func (dec *Decoder) emitIndexed(index int) *Header {
-	return dec.ht.Get(index).header
+// Mark the header list too large if the header list size would
+// exceed the maximum header list size by adding header field of which
+// name and value length sum to n.
+func (dec *Decoder) checkHeaderListSize(n uint) {
+	size := n + headerEntryOverhead
+
+	if size > dec.maxHeaderListSize ||
+		dec.headerListSize > dec.maxHeaderListSize-size {
+		dec.tooLarge = true
+	}
+}
+
+// Add the size of header to the header list size, and mark the
+// header list too large if the result exceeds the maximum header list
+// size.
+func (dec *Decoder) addHeaderListSize(header *Header) {
+	dec.checkHeaderListSize(uint(len(header.Name) + len(header.Value)))
+
+	dec.headerListSize += headerSize(header)
+}
+
+// Discard name and value of the current header field decoded so far
+// if they are not needed, and return true if the rest of them are not
+// needed either.  n is the number of octets of the header field known
+// to follow.  They are not needed once the header list is too large,
+// unless the header field is inserted into the header table.
+func (dec *Decoder) discard(n uint) bool {
+	if !dec.tooLarge {
+		return false
+	}
+
+	if dec.indexRequired &&
+		dec.decodedLen()+n+headerEntryOverhead <= dec.ht.maxTableSize {
+		return false
+	}
+
+	dec.discarded += uint(dec.nvbuf.Len())
+	dec.nvbuf.Reset()
+
+	return true
+}
+
+// Read string from src at most dec.left bytes and write it to nvbuf,
+// or skip it if it is not needed.  This function returns number of
+// bytes read.
+func (dec *Decoder) readString(src []byte) int {
+	if dec.discard(dec.left) {
+		n := min(len(src), int(dec.left))
+		dec.discarded += uint(n)
+
+		return n
+	}
+
+	return readString(dec.nvbuf, src, int(dec.left))
+}
+
+// The number of octets of Huffman-encoded string decoded at a time.
+const huffmanChunkSize = 256
+
+// Read Huffman-encoded string from src at most dec.left bytes, and
+// write decoded string to nvbuf.  src is decoded in chunks, so that
+// the header list size is checked, and the octets which are not
+// needed are discarded, before nvbuf grows too much.  This function
+// returns number of bytes read.
+func (dec *Decoder) readHuffman(src []byte) (int, error) {
+	src = src[:min(len(src), int(dec.left))]
+	n := 0
+
+	for {
+		end := min(len(src), n+huffmanChunkSize)
+
+		nread, err := readHuffman(dec.hdec, dec.nvbuf, src[n:end],
+			int(dec.left)-n)
+
+		n += nread
+
+		if err != nil {
+			return n, err
+		}
+
+		dec.checkHeaderListSize(dec.decodedLen())
+		dec.discard(0)
+
+		if n == len(src) {
+			return n, nil
+		}
+	}
+}
+
+// Return the length of header name and value of the header field
+// currently being decoded, including the discarded octets.
+func (dec *Decoder) decodedLen() uint {
+	n := uint(dec.nvbuf.Len()) + dec.discarded
+
+	if dec.opcode == opcodeIndname {
+		n += uint(len(dec.entName.header.Name))
+	}
+
+	return n
+}
+
+// Return the header field decoded by next(), and insert it into the
+// header table if required.  This function returns nil if the name
+// and value were discarded.
+func (dec *Decoder) emitHeader() *Header {
+	if dec.discarded > 0 {
+		dec.dropField()
+		return nil
+	}
+
+	switch dec.opcode {
+	case opcodeIndexed:
+		return dec.emitIndexed()
+	case opcodeNewname:
+		return dec.emitNewname()
+	default:
+		return dec.emitIndname()
+	}
+}
+
+// Pass the header field decoded by next() to emit as byte slices, and
+// insert it into the header table if required.  The slices are valid
+// until emit returns.
+func (dec *Decoder) emitBytes(emit func(name, value []byte, neverIndex bool) error) error {
+	if dec.opcode == opcodeIndexed {
+		header := &dec.ht.Get(dec.index).header
+
+		return emit(stringBytes(header.Name), stringBytes(header.Value),
+			header.NeverIndex)
+	}
+
+	if dec.discarded > 0 {
+		dec.dropField()
+		return nil
+	}
+
+	var name, value []byte
+
+	if dec.opcode == opcodeNewname {
+		name = dec.nvbuf.Bytes()[:dec.newnamelen]
+		value = dec.nvbuf.Bytes()[dec.newnamelen:]
+	} else {
+		name = stringBytes(dec.entName.header.Name)
+		value = dec.nvbuf.Bytes()
+	}
+
+	if dec.indexRequired {
+		var header *Header
+
+		if dec.opcode == opcodeNewname {
+			header = &Header{Name: string(name),
+				Value: string(value), NeverIndex: dec.neverIndex}
+		} else {
+			header = &Header{Name: dec.entName.header.Name,
+				Value: string(value), NeverIndex: dec.neverIndex}
+		}
+
+		entry := newHeaderTableEntry(header)
+		dec.ht.PushFront(entry)
+	}
+
+	err := emit(name, value, dec.neverIndex)
+
+	dec.entName = nil
+	dec.nvbuf.Reset()
+
+	return err
+}
+
+// Drop the header field decoded by next() of which name and value were
+// discarded.  If it is to be inserted, the header table is emptied,
+// since it is larger than the header table.
+func (dec *Decoder) dropField() {
+	if dec.indexRequired {
+		for dec.ht.tablelen > 0 {
+			dec.ht.PopBack()
+		}
+	}
+
+	dec.entName = nil
+	dec.nvbuf.Reset()
+	dec.discarded = 0
+}
+
+// Return the copy of header table entry, so that the caller cannot
+// modify the header table.
+func (dec *Decoder) emitIndexed() *Header {
+	header := dec.ht.Get(dec.index).header
+
+	return &header
 }
 
 func (dec *Decoder) emitNewname() *Header {
 	header := &Header{
-		string(dec.nvbuf.Bytes()[:dec.newnamelen]),
-		string(dec.nvbuf.Bytes()[dec.newnamelen:]),
-		dec.neverIndex,
+		Name:       string(dec.nvbuf.Bytes()[:dec.newnamelen]),
+		Value:      string(dec.nvbuf.Bytes()[dec.newnamelen:]),
+		NeverIndex: dec.neverIndex,
 	}
 
 	dec.nvbuf.Reset()
@@ -422,9 +987,9 @@ func (dec *Decoder) emitNewname() *Header {
 
 func (dec *Decoder) emitIndname() *Header {
 	header := &Header{
-		dec.entName.header.Name,
-		string(dec.nvbuf.Bytes()),
-		dec.neverIndex,
+		Name:       dec.entName.header.Name,
+		Value:      string(dec.nvbuf.Bytes()),
+		NeverIndex: dec.neverIndex,
 	}
 
 	dec.entName = nil
@@ -446,9 +1011,25 @@ func (dec *Decoder) checkHuffmanEncoded(b byte) {
 	dec.huffmanEncoded = (b & (1 << 7)) != 0
 }
 
-// Change maximum header table size to n.
+// Change maximum header table size to n.  If n is smaller than the
+// header table size currently in use, the next header block must
+// begin with dynamic table size update which is less than or equal to
+// n.  Increasing n does not change the header table size in use until
+// the encoder sends dynamic table size update.
 func (dec *Decoder) ChangeTableSize(n uint) {
 	dec.settingsMaxTableSize = n
+
+	// dec.ht.maxTableSize is the size the encoder set by the last
+	// dynamic table size update, or the smaller size we set since
+	// then.
+	if n >= dec.ht.maxTableSize {
+		return
+	}
+
+	if n < dec.settingsMinTableSize {
+		dec.settingsMinTableSize = n
+	}
+
 	dec.ht.ChangeTableSize(n)
 }
 
@@ -488,16 +1069,16 @@ func readInt(src []byte, initial uint, initialShift, prefix uint) (n uint, final
 		add := uint(c) & 0x7f
 
 		if (uint32Max >> shift) < add {
-			err = fmt.Errorf("overflow on shift: add %v, shift %v",
-				add, shift)
+			err = fmt.Errorf("%w: overflow on shift: add %v, shift %v",
+				ErrIntegerOverflow, add, shift)
 			return
 		}
 
 		add <<= shift
 
 		if uint32Max-add < n {
-			err = fmt.Errorf("overflow on addition: add %v, n %v",
-				add, n)
+			err = fmt.Errorf("%w: overflow on addition: add %v, n %v",
+				ErrIntegerOverflow, add, n)
 			return
 		}
 
@@ -554,3 +1135,9 @@ func readString(dst *bytes.Buffer, src []byte, left int) int {
 
 	return left
 }
+
+// Return the bytes of s without copying.  The returned slice must not
+// be modified.
+func stringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
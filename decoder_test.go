@@ -25,31 +25,34 @@ package hpack
 
 import (
 	"bytes"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 )
 
 func TestDecoderDecodeIndex(t *testing.T) {
 	dec := NewDecoder()
 
-	input := &bytes.Buffer{}
+	var input []byte
 
 	// Encode: 5. :path: /index.html
-	encodeIndex(input, 5-1)
+	input = appendIndex(input, 5-1)
 
-	header, nread, err := dec.Decode(input.Bytes(), true)
+	header, nread, err := dec.Decode(input, true)
 
 	if err != nil {
 		t.Errorf("dec.Decode(...) returned error %v", err)
 	}
 
-	if nread != input.Len() {
-		t.Error("dec.Decode(...) read %v, want %v",
-			nread, input.Len())
+	if nread != len(input) {
+		t.Errorf("dec.Decode(...) read %v, want %v",
+			nread, len(input))
 	}
 
 	expected := staticTable[5-1].header
 
-	if expected != header {
+	if expected != *header {
 		t.Errorf("dec.Decode(...) returned %v, want %v",
 			header, expected)
 	}
@@ -58,21 +61,21 @@ func TestDecoderDecodeIndex(t *testing.T) {
 func TestDecoderDecodeIndname(t *testing.T) {
 	dec := NewDecoder()
 
-	input := &bytes.Buffer{}
+	var input []byte
 
 	// Encode cache-control: private, with indexing
-	encodeIndname(input, 24-1, "private", true, false)
-	nread1 := input.Len()
+	input = appendIndname(input, 24-1, "private", true, false, HuffmanDefault)
+	nread1 := len(input)
 
 	// Encode authorization: basic aGVsbG86d29ybGQ=", with never
 	// indexing
-	encodeIndname(input, 23-1, "basic aGVsbG86d29ybGQ=", false, true)
-	nread2 := input.Len() - nread1
+	input = appendIndname(input, 23-1, "basic aGVsbG86d29ybGQ=", false, true, HuffmanDefault)
+	nread2 := len(input) - nread1
 
 	expected1 := Header{"cache-control", "private", false}
 	expected2 := Header{"authorization", "basic aGVsbG86d29ybGQ=", true}
 
-	header, nread, err := dec.Decode(input.Bytes(), true)
+	header, nread, err := dec.Decode(input, true)
 
 	if err != nil {
 		t.Errorf("dec.Decode(...) returned error %v", err)
@@ -87,7 +90,7 @@ func TestDecoderDecodeIndname(t *testing.T) {
 			*header, expected1)
 	}
 
-	header, nread, err = dec.Decode(input.Bytes()[nread1:], true)
+	header, nread, err = dec.Decode(input[nread1:], true)
 
 	if err != nil {
 		t.Errorf("dec.Decode(...) returned error %v", err)
@@ -106,22 +109,22 @@ func TestDecoderDecodeIndname(t *testing.T) {
 func TestDecoderDecodeNewname(t *testing.T) {
 	dec := NewDecoder()
 
-	input := &bytes.Buffer{}
+	var input []byte
 
 	// Encode cache-control: private, with indexing
-	encodeNewname(input, "cache-control", "private", true, false)
-	nread1 := input.Len()
+	input = appendNewname(input, "cache-control", "private", true, false, HuffmanDefault)
+	nread1 := len(input)
 
 	// Encode authorization: basic aGVsbG86d29ybGQ=", with never
 	// indexing
-	encodeNewname(input, "authorization", "basic aGVsbG86d29ybGQ=",
-		false, true)
-	nread2 := input.Len() - nread1
+	input = appendNewname(input, "authorization", "basic aGVsbG86d29ybGQ=",
+		false, true, HuffmanDefault)
+	nread2 := len(input) - nread1
 
 	expected1 := Header{"cache-control", "private", false}
 	expected2 := Header{"authorization", "basic aGVsbG86d29ybGQ=", true}
 
-	header, nread, err := dec.Decode(input.Bytes(), true)
+	header, nread, err := dec.Decode(input, true)
 
 	if err != nil {
 		t.Errorf("dec.Decode(...) returned error %v", err)
@@ -136,7 +139,7 @@ func TestDecoderDecodeNewname(t *testing.T) {
 			*header, expected1)
 	}
 
-	header, nread, err = dec.Decode(input.Bytes()[nread1:], true)
+	header, nread, err = dec.Decode(input[nread1:], true)
 
 	if err != nil {
 		t.Errorf("dec.Decode(...) returned error %v", err)
@@ -155,14 +158,14 @@ func TestDecoderDecodeNewname(t *testing.T) {
 func TestDecoderDecodeStringEndPrematurely(t *testing.T) {
 	dec := NewDecoder()
 
-	input := &bytes.Buffer{}
+	var input []byte
 
 	// Encode authorization: basic aGVsbG86d29ybGQ=", with never
 	// indexing
-	encodeNewname(input, "authorization", "basic aGVsbG86d29ybGQ=",
-		false, true)
+	input = appendNewname(input, "authorization", "basic aGVsbG86d29ybGQ=",
+		false, true, HuffmanDefault)
 
-	_, _, err := dec.Decode(input.Bytes()[:input.Len()-1], true)
+	_, _, err := dec.Decode(input[:len(input)-1], true)
 
 	if err == nil {
 		t.Errorf("dec.Decode(...) must return error")
@@ -200,15 +203,988 @@ func TestDecoderHandleIllegalContextUpdate(t *testing.T) {
 	}
 }
 
-func TestReadIntOverflow(t *testing.T) {
+func TestDecoderTableSizeUpdateAfterHeader(t *testing.T) {
+	var input []byte
+
+	input = appendIndex(input, 2-1)
+	input = appendTableSize(input, 0)
+
+	dec := NewDecoder()
+
+	_, nread, err := dec.Decode(input, true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+
+	_, _, err = dec.Decode(input[nread:], true)
+
+	if !errors.Is(err, ErrTableSizeUpdateAfterHeader) {
+		t.Errorf("dec.Decode(...) returned %v, want %v",
+			err, ErrTableSizeUpdateAfterHeader)
+	}
+
+	// Draft09 decoder accepts dynamic table size update anywhere.
+	dec = NewDecoderSpec(Draft09)
+
+	_, nread, err = dec.Decode(input, true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+
+	_, _, err = dec.Decode(input[nread:], true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+
+	// Table size update at the beginning of next header block is
+	// fine.
+	dec = NewDecoder()
+
+	_, nread, err = dec.Decode(input[:nread], true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+
+	_, _, err = dec.Decode(input[nread:], true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+}
+
+// Check that a header block of draft-09, in which dynamic table size
+// update follows header fields, is accepted only by Draft09 decoder.
+func TestDecoderDraft09Block(t *testing.T) {
+	var block []byte
+
+	block = appendNewname(block, "alpha", "bravo", true, false, HuffmanDefault)
+	block = appendTableSize(block, 0)
+	block = appendTableSize(block, 4096)
+	block = appendNewname(block, "charlie", "delta", true, false, HuffmanDefault)
+	// charlie: delta is the only entry in dynamic table.
+	block = appendIndex(block, 62-1)
+
+	expected := []Header{
+		{"alpha", "bravo", false},
+		{"charlie", "delta", false},
+		{"charlie", "delta", false},
+	}
+
+	dec := NewDecoderSpec(Draft09)
+
+	headers, err := dec.DecodeFull(block)
+
+	if err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("dec.DecodeFull(...) = %v, want %v", headers, expected)
+	}
+
+	if dec.DynamicTableLen() != 1 {
+		t.Errorf("dec.DynamicTableLen() = %v, want %v",
+			dec.DynamicTableLen(), 1)
+	}
+
+	dec = NewDecoder()
+
+	_, err = dec.DecodeFull(block)
+
+	if !errors.Is(err, ErrTableSizeUpdateAfterHeader) {
+		t.Errorf("dec.DecodeFull(...) returned %v, want %v",
+			err, ErrTableSizeUpdateAfterHeader)
+	}
+
+	var derr *DecodingError
+
+	if !errors.As(err, &derr) || derr.Code() != ErrCodeCompression {
+		t.Errorf("dec.DecodeFull(...) returned %v, want *DecodingError with %v",
+			err, ErrCodeCompression)
+	}
+}
+
+func TestDecoderTooManyTableSizeUpdates(t *testing.T) {
+	var input []byte
+
+	input = appendTableSize(input, 0)
+	input = appendTableSize(input, 4096)
+	input = appendIndex(input, 2-1)
+
+	dec := NewDecoder()
+
+	_, _, err := dec.Decode(input, true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+
+	input = input[:0]
+
+	input = appendTableSize(input, 0)
+	input = appendTableSize(input, 1024)
+	input = appendTableSize(input, 4096)
+	input = appendIndex(input, 2-1)
+
+	dec = NewDecoder()
+
+	_, _, err = dec.Decode(input, true)
+
+	if !errors.Is(err, ErrTooManyTableSizeUpdates) {
+		t.Errorf("dec.Decode(...) returned %v, want %v",
+			err, ErrTooManyTableSizeUpdates)
+	}
+
+	dec = NewDecoderSpec(Draft09)
+
+	_, _, err = dec.Decode(input, true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+}
+
+func TestDecoderTableSizeUpdateRequired(t *testing.T) {
+	var field []byte
+
+	field = appendIndex(field, 2-1)
+
+	// Reducing header table size requires dynamic table size
+	// update, even if it is increased again.
+	dec := NewDecoder()
+	dec.ChangeTableSize(1024)
+	dec.ChangeTableSize(4096)
+
+	_, _, err := dec.Decode(field, true)
+
+	if !errors.Is(err, ErrTableSizeUpdateRequired) {
+		t.Errorf("dec.Decode(...) returned %v, want %v",
+			err, ErrTableSizeUpdateRequired)
+	}
+
+	// The update must be less than or equal to the minimum
+	// size.
+	var input []byte
+
+	input = appendTableSize(input, 4096)
+	input = append(input, field...)
+
+	dec = NewDecoder()
+	dec.ChangeTableSize(1024)
+	dec.ChangeTableSize(4096)
+
+	_, _, err = dec.Decode(input, true)
+
+	if !errors.Is(err, ErrTableSizeUpdateRequired) {
+		t.Errorf("dec.Decode(...) returned %v, want %v",
+			err, ErrTableSizeUpdateRequired)
+	}
+
+	input = input[:0]
+
+	input = appendTableSize(input, 1024)
+	input = appendTableSize(input, 4096)
+	input = append(input, field...)
+
+	dec = NewDecoder()
+	dec.ChangeTableSize(1024)
+	dec.ChangeTableSize(4096)
+
+	_, _, err = dec.Decode(input, true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+
+	// Now the update was acknowledged, and it is not required
+	// anymore.
+	_, _, err = dec.Decode(field, true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+
+	// Increasing header table size does not require update.
+	dec = NewDecoder()
+	dec.ChangeTableSize(8192)
+
+	_, _, err = dec.Decode(field, true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+
+	// Reducing header table size which was increased, but is still
+	// larger than the one the encoder uses does not require update.
+	dec = NewDecoder()
+	dec.ChangeTableSize(8192)
+	dec.ChangeTableSize(6000)
+
+	if got, want := dec.MaxDynamicTableSize(), uint(DEFAULT_HEADER_TABLE_SIZE); got != want {
+		t.Errorf("dec.MaxDynamicTableSize() = %v, want %v", got, want)
+	}
+
+	_, _, err = dec.Decode(field, true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+
+	// Reducing it below the one the encoder uses requires update.
+	dec = NewDecoder()
+	dec.ChangeTableSize(8192)
+	dec.ChangeTableSize(2048)
+
+	_, _, err = dec.Decode(field, true)
+
+	if !errors.Is(err, ErrTableSizeUpdateRequired) {
+		t.Errorf("dec.Decode(...) returned %v, want %v",
+			err, ErrTableSizeUpdateRequired)
+	}
+
+	dec = NewDecoderSpec(Draft09)
+	dec.ChangeTableSize(1024)
+
+	_, _, err = dec.Decode(field, true)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+}
+
+func TestDecoderOversizedEntry(t *testing.T) {
+	dec := NewDecoder()
+
+	// alpha: bravo is indexed with 4096 bytes table.
+	block := appendNewname(nil, "alpha", "bravo", true, false,
+		HuffmanNever)
+
+	if _, err := dec.DecodeFull(block); err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	// Shrink the table to 42 bytes, which can hold alpha: bravo,
+	// but not alpha: bravo!.  Adding it empties the table.
+	block = appendTableSize(nil, 42)
+	block = appendIndname(block, staticTableLength(), "bravo!", true,
+		false, HuffmanNever)
+
+	if _, err := dec.DecodeFull(block); err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	if dec.ht.tablelen != 0 {
+		t.Errorf("dec.ht.tablelen = %v, want %v", dec.ht.tablelen, 0)
+	}
+
+	// The oversized entry must not be referenced.
+	_, err := dec.DecodeFull(appendIndex(nil, staticTableLength()))
+
+	if !errors.Is(err, ErrIndexTooLarge) {
+		t.Errorf("dec.DecodeFull(...) returned %v, want %v", err,
+			ErrIndexTooLarge)
+	}
+}
+
+func TestDecoderMaxHeaderListSize(t *testing.T) {
+	nva := []*Header{
+		// 5 + 5 + 32 = 42
+		&Header{"alpha", "bravo", false},
+		// 7 + 3 + 32 = 42
+		&Header{":method", "GET", false},
+		// 7 + 16 + 32 = 55
+		&Header{"charlie", "delta echo golf!", false},
+	}
+
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
 	encoded := &bytes.Buffer{}
+
+	// Both literal and indexed alpha: bravo are tested.
+	enc.Encode(encoded, nva)
+	blocklen := encoded.Len()
+	enc.Encode(encoded, nva)
+
+	for _, test := range []struct {
+		max uint
+		ok  bool
+	}{
+		{139, true},
+		{138, false},
+		{84, false},
+		// Fail early before reading value of the first field
+		{41, false},
+	} {
+		dec := NewDecoder()
+		dec.SetMaxHeaderListSize(test.max)
+
+		var err error
+
+		for i := 0; i < 2 && err == nil; i++ {
+			block := encoded.Bytes()[:blocklen]
+			if i == 1 {
+				block = encoded.Bytes()[blocklen:]
+			}
+
+			for cur := 0; ; {
+				var header *Header
+				var nread int
+
+				header, nread, err = dec.Decode(block[cur:], true)
+
+				if err != nil || header == nil {
+					break
+				}
+
+				cur += nread
+			}
+		}
+
+		if test.ok && err != nil {
+			t.Errorf("max = %v: dec.Decode(...) returned error %v",
+				test.max, err)
+		}
+
+		if !test.ok && !errors.Is(err, ErrHeaderListTooLarge) {
+			t.Errorf("max = %v: dec.Decode(...) returned %v, want %v",
+				test.max, err, ErrHeaderListTooLarge)
+		}
+	}
+}
+
+// Check that the header block exceeding the maximum header list size
+// does not break the decoder.
+func TestDecoderMaxHeaderListSizeRecover(t *testing.T) {
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	encoded := &bytes.Buffer{}
+
+	// 5 + 5 + 32 + 7 + 200 + 32 + 4 + 7 + 32 = 324
+	enc.Encode(encoded, []*Header{
+		&Header{"alpha", "bravo", false},
+		NewHeader("charlie", strings.Repeat("c", 200), false),
+		NewHeader("echo", "foxtrot", false),
+	})
+	block1 := bytes.Clone(encoded.Bytes())
+
+	// The header fields are indexed in block1.  5 + 5 + 32 + 4 + 7 +
+	// 32 = 85
+	nva := []Header{
+		{Name: "alpha", Value: "bravo"},
+		{Name: "echo", Value: "foxtrot"},
+	}
+
+	encoded.Reset()
+	enc.Encode(encoded, []*Header{&nva[0], &nva[1]})
+	block2 := encoded.Bytes()
+
+	for _, test := range []struct {
+		name   string
+		decode func(dec *Decoder, block []byte) ([]Header, error)
+	}{
+		{"DecodeFull", (*Decoder).DecodeFull},
+		{"DecodeBytesFunc", func(dec *Decoder, block []byte) ([]Header, error) {
+			var headers []Header
+
+			err := dec.DecodeBytesFunc(block, true, func(name, value []byte, neverIndex bool) error {
+				headers = append(headers, Header{Name: string(name),
+					Value: string(value)})
+				return nil
+			})
+
+			return headers, err
+		}},
+		{"Decode", func(dec *Decoder, block []byte) ([]Header, error) {
+			var headers []Header
+			var blockErr error
+
+			for cur := 0; cur < len(block); {
+				header, nread, err := dec.Decode(block[cur:], true)
+
+				cur += nread
+
+				if err != nil {
+					if blockErr == nil {
+						blockErr = err
+					}
+
+					continue
+				}
+
+				if header != nil {
+					headers = append(headers, *header)
+				}
+			}
+
+			return headers, blockErr
+		}},
+	} {
+		dec := NewDecoder()
+		dec.SetMaxHeaderListSize(100)
+
+		headers, err := test.decode(dec, block1)
+
+		if !errors.Is(err, ErrHeaderListTooLarge) {
+			t.Errorf("%v: block1 returned %v, want %v", test.name,
+				err, ErrHeaderListTooLarge)
+		}
+
+		if !reflect.DeepEqual(headers, nva[:1]) {
+			t.Errorf("%v: block1 returned %v, want %v", test.name,
+				headers, nva[:1])
+		}
+
+		headers, err = test.decode(dec, block2)
+
+		if err != nil {
+			t.Errorf("%v: block2 returned error %v", test.name, err)
+		}
+
+		if !reflect.DeepEqual(headers, nva) {
+			t.Errorf("%v: block2 returned %v, want %v", test.name,
+				headers, nva)
+		}
+
+		if _, err := dec.MarshalBinary(); err != nil {
+			t.Errorf("%v: dec.MarshalBinary() returned error %v",
+				test.name, err)
+		}
+	}
+}
+
+// Check that the header field which is not needed after the header
+// list becomes too large is not buffered.
+func TestDecoderMaxHeaderListSizeDiscard(t *testing.T) {
+	dec := NewDecoder()
+	dec.SetMaxHeaderListSize(100)
+
+	block := appendNewname(nil, "alpha", "bravo", true, false,
+		HuffmanNever)
+
+	if _, err := dec.DecodeFull(block); err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	value := strings.Repeat("v", 8192)
+
+	for _, huffman := range []HuffmanPolicy{HuffmanNever, HuffmanAlways} {
+		// Incremental indexing of header field larger than the
+		// table empties the table.
+		block = appendNewname(nil, "x-large", value, true, false,
+			huffman)
+		block = appendIndex(block, 2-1)
+
+		headers, err := dec.DecodeFull(block)
+
+		if !errors.Is(err, ErrHeaderListTooLarge) || len(headers) != 0 {
+			t.Errorf("dec.DecodeFull(...) = (%v, %v), want (%v, %v)",
+				headers, err, nil, ErrHeaderListTooLarge)
+		}
+
+		if dec.ht.tablelen != 0 {
+			t.Errorf("dec.ht.tablelen = %v, want %v",
+				dec.ht.tablelen, 0)
+		}
+
+		if dec.nvbuf.Cap() >= len(value) {
+			t.Errorf("dec.nvbuf.Cap() = %v, want < %v",
+				dec.nvbuf.Cap(), len(value))
+		}
+	}
+}
+
+func TestDecodingError(t *testing.T) {
+	var input []byte
+
+	// Encode: 2. :method: GET
+	input = appendIndex(input, 2-1)
+	// Index 0 is illegal
+	input = append(input, 0x80)
+
+	dec := NewDecoder()
+
+	_, nread, err := dec.Decode(input, false)
+
+	if err != nil {
+		t.Errorf("dec.Decode(...) returned error %v", err)
+	}
+
+	_, _, err = dec.Decode(input[nread:], true)
+
+	var decErr *DecodingError
+
+	if !errors.As(err, &decErr) {
+		t.Fatalf("dec.Decode(...) returned %T, want *DecodingError", err)
+	}
+
+	if !errors.Is(err, ErrIndexZero) {
+		t.Errorf("errors.Is(%v, ErrIndexZero) = false", err)
+	}
+
+	// The offset is counted from the beginning of header block.
+	if decErr.Offset != 2 {
+		t.Errorf("decErr.Offset = %v, want %v", decErr.Offset, 2)
+	}
+
+	if decErr.Instruction != InstructionIndexed {
+		t.Errorf("decErr.Instruction = %v, want %v",
+			decErr.Instruction, InstructionIndexed)
+	}
+
+	if decErr.State != "index" {
+		t.Errorf("decErr.State = %v, want %v", decErr.State, "index")
+	}
+
+	if decErr.Code() != ErrCodeCompression {
+		t.Errorf("decErr.Code() = %v, want %v",
+			decErr.Code(), ErrCodeCompression)
+	}
+
+	// Further call returns the same error.
+	_, _, err2 := dec.Decode(input, true)
+
+	if err2 != err {
+		t.Errorf("dec.Decode(...) returned %v, want %v", err2, err)
+	}
+
+	// Header list too large is not *DecodingError, since the
+	// compression context is intact.  The stream is reset with
+	// PROTOCOL_ERROR.
+	dec = NewDecoder()
+	dec.SetMaxHeaderListSize(1)
+
+	_, _, err = dec.Decode(input, true)
+
+	var sizeErr *HeaderListTooLargeError
+
+	if !errors.As(err, &sizeErr) || errors.As(err, &decErr) {
+		t.Fatalf("dec.Decode(...) returned %T, want *HeaderListTooLargeError", err)
+	}
+
+	if !errors.Is(err, ErrHeaderListTooLarge) {
+		t.Errorf("errors.Is(%v, ErrHeaderListTooLarge) = false", err)
+	}
+
+	if sizeErr.MaxHeaderListSize != 1 || sizeErr.Code() != ErrCodeProtocol {
+		t.Errorf("(sizeErr.MaxHeaderListSize, sizeErr.Code()) = (%v, %v), want (%v, %v)",
+			sizeErr.MaxHeaderListSize, sizeErr.Code(), 1,
+			ErrCodeProtocol)
+	}
+}
+
+func TestDecoderHuffmanError(t *testing.T) {
+	var input []byte
+
+	input = appendNewname(input, "alpha", "bravo", false, false, HuffmanDefault)
+
+	// Corrupt Huffman padding of value
+	input[len(input)-1] &^= 0x1
+
+	dec := NewDecoder()
+
+	_, _, err := dec.Decode(input, true)
+
+	if !errors.Is(err, ErrHuffmanInvalidPadding) {
+		t.Errorf("dec.Decode(...) returned %v, want %v",
+			err, ErrHuffmanInvalidPadding)
+	}
+}
+
+func TestReadIntOverflow(t *testing.T) {
 	prefix := uint(7)
 
-	encodeInteger(encoded, uint64(uint32Max)+1, prefix)
+	encoded := appendInteger(nil, 0, uint64(uint32Max)+1, prefix)
 
-	_, _, _, _, err := readInt(encoded.Bytes(), 0, 0, prefix)
+	_, _, _, _, err := readInt(encoded, 0, 0, prefix)
 
-	if err == nil {
-		t.Errorf("readInt(...) must return overflow error")
+	if !errors.Is(err, ErrIntegerOverflow) {
+		t.Errorf("readInt(...) returned %v, want %v",
+			err, ErrIntegerOverflow)
+	}
+}
+
+func TestDecoderDecodeFull(t *testing.T) {
+	nva := []*Header{
+		&Header{":method", "GET", false},
+		&Header{"alpha", "bravo", false},
+		&Header{"cache-control", "private", false},
+	}
+
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	dec := NewDecoder()
+
+	for i := 0; i < 2; i++ {
+		encoded := &bytes.Buffer{}
+
+		enc.Encode(encoded, nva)
+
+		headers, err := dec.DecodeFull(encoded.Bytes())
+
+		if err != nil {
+			t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+		}
+
+		if len(headers) != len(nva) {
+			t.Fatalf("len(headers) = %v, want %v",
+				len(headers), len(nva))
+		}
+
+		for j := range nva {
+			if headers[j] != *nva[j] {
+				t.Errorf("headers[%v] = %v, want %v",
+					j, headers[j], *nva[j])
+			}
+		}
+	}
+
+	// Header block ends prematurely.
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, []*Header{&Header{"charlie", "delta", false}})
+
+	_, err := dec.DecodeFull(encoded.Bytes()[:encoded.Len()-1])
+
+	if !errors.Is(err, ErrInputEndedPrematurely) {
+		t.Errorf("dec.DecodeFull(...) returned %v, want %v",
+			err, ErrInputEndedPrematurely)
+	}
+}
+
+func TestDecoderWrite(t *testing.T) {
+	nva := []*Header{
+		&Header{":method", "GET", false},
+		&Header{"alpha", "bravo", false},
+		&Header{"cache-control", "private", false},
+	}
+
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	dec := NewDecoder()
+
+	var headers []Header
+
+	dec.SetEmitFunc(func(header Header) error {
+		headers = append(headers, header)
+		return nil
+	})
+
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, nva)
+
+	// Feed 1 byte at a time, so that every header field is split.
+	for i := 0; i < encoded.Len(); i++ {
+		n, err := dec.Write(encoded.Bytes()[i : i+1])
+
+		if err != nil || n != 1 {
+			t.Fatalf("dec.Write(...) = %v, %v, want 1, nil", n, err)
+		}
+	}
+
+	if err := dec.Close(); err != nil {
+		t.Errorf("dec.Close() returned error %v", err)
+	}
+
+	if len(headers) != len(nva) {
+		t.Fatalf("len(headers) = %v, want %v", len(headers), len(nva))
+	}
+
+	for i := range nva {
+		if headers[i] != *nva[i] {
+			t.Errorf("headers[%v] = %v, want %v",
+				i, headers[i], *nva[i])
+		}
+	}
+
+	// Close in the middle of header field.
+	encoded.Reset()
+
+	enc.Encode(encoded, []*Header{&Header{"charlie", "delta", false}})
+
+	dec.Write(encoded.Bytes()[:encoded.Len()-1])
+
+	if err := dec.Close(); !errors.Is(err, ErrInputEndedPrematurely) {
+		t.Errorf("dec.Close() returned %v, want %v",
+			err, ErrInputEndedPrematurely)
+	}
+}
+
+func TestDecoderWriteEmitError(t *testing.T) {
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	dec := NewDecoder()
+
+	stop := errors.New("stop")
+	nemit := 0
+
+	dec.SetEmitFunc(func(header Header) error {
+		nemit++
+		return stop
+	})
+
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, []*Header{
+		&Header{":method", "GET", false},
+		&Header{"alpha", "bravo", false},
+	})
+
+	_, err := dec.Write(encoded.Bytes())
+
+	if err != stop {
+		t.Errorf("dec.Write(...) returned %v, want %v", err, stop)
+	}
+
+	if nemit != 1 {
+		t.Errorf("nemit = %v, want %v", nemit, 1)
+	}
+
+	// The decoder is no longer usable.
+	if err := dec.Close(); err != stop {
+		t.Errorf("dec.Close() returned %v, want %v", err, stop)
+	}
+}
+
+func TestDecoderDecodeFunc(t *testing.T) {
+	nva := []*Header{
+		&Header{":method", "GET", false},
+		&Header{"alpha", "bravo", false},
+		&Header{"authorization", "basic aGVsbG86d29ybGQ=", false},
+	}
+
+	encoded := &bytes.Buffer{}
+
+	NewEncoder(DEFAULT_HEADER_TABLE_SIZE).Encode(encoded, nva)
+
+	// Split header block into 2 chunks at every position.
+	for i := 0; i <= encoded.Len(); i++ {
+		dec := NewDecoder()
+
+		var headers []Header
+
+		emit := func(header Header) error {
+			headers = append(headers, header)
+			return nil
+		}
+
+		if err := dec.DecodeFunc(encoded.Bytes()[:i], false, emit); err != nil {
+			t.Fatalf("dec.DecodeFunc(...) returned error %v", err)
+		}
+
+		if err := dec.DecodeFunc(encoded.Bytes()[i:], true, emit); err != nil {
+			t.Fatalf("dec.DecodeFunc(...) returned error %v", err)
+		}
+
+		if len(headers) != len(nva) {
+			t.Fatalf("len(headers) = %v, want %v",
+				len(headers), len(nva))
+		}
+
+		for j := range nva {
+			if headers[j] != *nva[j] {
+				t.Errorf("headers[%v] = %v, want %v",
+					j, headers[j], *nva[j])
+			}
+		}
+	}
+
+	// emit's error is propagated.
+	dec := NewDecoder()
+	stop := errors.New("stop")
+
+	err := dec.DecodeFunc(encoded.Bytes(), true, func(header Header) error {
+		if header.Name == "alpha" {
+			return stop
+		}
+
+		return nil
+	})
+
+	if err != stop {
+		t.Errorf("dec.DecodeFunc(...) returned %v, want %v", err, stop)
+	}
+}
+
+func TestDecoderDecodeBytesFunc(t *testing.T) {
+	nva := []*Header{
+		&Header{":method", "GET", false},
+		&Header{"alpha", "bravo", false},
+		&Header{"cache-control", "private", false},
+		&Header{":path", "/index.html", false},
+	}
+
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	dec := NewDecoder()
+
+	// The second header block refers to the entries inserted by the
+	// first one.
+	for i := 0; i < 2; i++ {
+		encoded := &bytes.Buffer{}
+
+		enc.Encode(encoded, nva)
+
+		var headers []Header
+
+		emit := func(name, value []byte, neverIndex bool) error {
+			headers = append(headers,
+				Header{Name: string(name), Value: string(value), NeverIndex: neverIndex})
+			return nil
+		}
+
+		n := encoded.Len() / 2
+
+		if err := dec.DecodeBytesFunc(encoded.Bytes()[:n], false, emit); err != nil {
+			t.Fatalf("dec.DecodeBytesFunc(...) returned error %v", err)
+		}
+
+		if err := dec.DecodeBytesFunc(encoded.Bytes()[n:], true, emit); err != nil {
+			t.Fatalf("dec.DecodeBytesFunc(...) returned error %v", err)
+		}
+
+		if len(headers) != len(nva) {
+			t.Fatalf("len(headers) = %v, want %v",
+				len(headers), len(nva))
+		}
+
+		for j := range nva {
+			if headers[j] != *nva[j] {
+				t.Errorf("headers[%v] = %v, want %v",
+					j, headers[j], *nva[j])
+			}
+		}
+	}
+
+	if dec.ht.tablelen != enc.ht.tablelen {
+		t.Errorf("dec.ht.tablelen = %v, want %v",
+			dec.ht.tablelen, enc.ht.tablelen)
+	}
+}
+
+// Return header block which consists of literal header fields
+// without indexing, so that it can be decoded repeatedly by the same
+// decoder.
+func benchmarkHeaderBlock() []byte {
+	var block []byte
+
+	block = appendIndex(block, 2-1)
+	block = appendIndex(block, 7-1)
+	block = appendIndname(block, 1-1, "www.example.org", false, false, HuffmanDefault)
+	block = appendIndname(block, 4-1, "/resource/index.html?q=1", false, false, HuffmanDefault)
+	block = appendIndname(block, 58-1, "Mozilla/5.0 (X11; Linux x86_64)", false, false, HuffmanDefault)
+	block = appendIndname(block, 19-1, "*/*", false, false, HuffmanDefault)
+	block = appendNewname(block, "x-request-id", "0123456789abcdef", false, false, HuffmanDefault)
+	block = appendNewname(block, "x-forwarded-for", "192.0.2.1", false, false, HuffmanDefault)
+
+	return block
+}
+
+func BenchmarkDecoderDecode(b *testing.B) {
+	block := benchmarkHeaderBlock()
+	dec := NewDecoder()
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		for src := block; len(src) > 0; {
+			_, nread, err := dec.Decode(src, true)
+
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			src = src[nread:]
+		}
+	}
+}
+
+func BenchmarkDecoderDecodeFunc(b *testing.B) {
+	block := benchmarkHeaderBlock()
+	dec := NewDecoder()
+	n := 0
+
+	emit := func(header Header) error {
+		n += len(header.Name) + len(header.Value)
+		return nil
+	}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if err := dec.DecodeFunc(block, true, emit); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoderDecodeBytesFunc(b *testing.B) {
+	block := benchmarkHeaderBlock()
+	dec := NewDecoder()
+	n := 0
+
+	emit := func(name, value []byte, neverIndex bool) error {
+		n += len(name) + len(value)
+		return nil
+	}
+
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		if err := dec.DecodeBytesFunc(block, true, emit); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestDecoderResultNotAliasTable(t *testing.T) {
+	nva := []*Header{
+		// Static table entry
+		&Header{":method", "GET", false},
+		&Header{"alpha", "bravo", false},
+	}
+
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	dec := NewDecoder()
+
+	// The second header block refers to the dynamic table entry
+	// for "alpha".
+	for i := 0; i < 2; i++ {
+		encoded := &bytes.Buffer{}
+
+		enc.Encode(encoded, nva)
+
+		for src := encoded.Bytes(); len(src) > 0; {
+			header, nread, err := dec.Decode(src, true)
+
+			if err != nil {
+				t.Fatalf("dec.Decode(...) returned error %v", err)
+			}
+
+			src = src[nread:]
+
+			if header != nil {
+				header.Name = "mutated"
+				header.Value = "mutated"
+				header.NeverIndex = true
+			}
+		}
+	}
+
+	if staticTable[2-1].header != (Header{Name: ":method", Value: "GET"}) {
+		t.Errorf("staticTable[2-1].header = %v, want %v",
+			staticTable[2-1].header, Header{Name: ":method", Value: "GET"})
+	}
+
+	if dec.ht.dynget(0).header != *nva[1] {
+		t.Errorf("dec.ht.dynget(0).header = %v, want %v",
+			dec.ht.dynget(0).header, *nva[1])
+	}
+
+	// Mutating header fields passed to Encoder does not change its
+	// header table either.
+	nva[1].Value = "mutated"
+
+	if enc.ht.dynget(0).header != (Header{Name: "alpha", Value: "bravo"}) {
+		t.Errorf("enc.ht.dynget(0).header = %v, want %v",
+			enc.ht.dynget(0).header, Header{Name: "alpha", Value: "bravo"})
 	}
 }
@@ -25,6 +25,7 @@ package hpack
 
 import (
 	"bytes"
+	"io"
 )
 
 // A encoder encodes header list to byte string using HPACK algorithm.
@@ -39,14 +40,249 @@ type Encoder struct {
 	// true if we have to emit context update in the next
 	// encoding.
 	contextUpdate bool
+	// Maximum header list size the peer accepts.
+	maxHeaderListSize uint
+	// Validator set by SetValidator(), or nil.
+	validator *Validator
+	// Buffer used by EncodeTo().
+	buf []byte
+	// Policy which decides how header field is indexed.
+	indexingPolicy IndexingPolicy
+	// Policy which decides whether string is Huffman encoded.
+	huffmanPolicy HuffmanPolicy
+	// Huffman policies which override huffmanPolicy, keyed by
+	// header field name.
+	huffmanNames map[string]HuffmanPolicy
+	// Detector of sensitive header fields, or nil.
+	sensitiveHeaders *SensitiveHeaders
+	// true if cookie header field is split into crumbs.
+	splitCookies bool
 }
 
+// EncoderOptions specifies the configuration of Encoder created by
+// NewEncoderWithOptions.  The zero value gives the same Encoder as
+// NewEncoder(DEFAULT_HEADER_TABLE_SIZE).
+//
+// In the table sizes, 0 means that the size is not set, and the
+// default is used.  Since 0 cannot be given as a size, the maximum
+// header table size 0 is selected by NoDynamicTable instead.  The
+// initial header table size 0 with larger maximum is not expressible
+// here; call ChangeTableSize(0) after NewEncoderWithOptions.
+type EncoderOptions struct {
+	// Maximum header table size this encoder supports.  If it is
+	// 0, DEFAULT_HEADER_TABLE_SIZE is used.
+	MaxTableSize uint
+	// If it is true, the maximum header table size is 0, and
+	// dynamic table is not used.  It takes precedence over both
+	// MaxTableSize and InitialTableSize, which are ignored.  This
+	// gives the same Encoder as NewEncoder(0).
+	NoDynamicTable bool
+	// Header table size used initially.  If it is not 0, it is
+	// applied as if ChangeTableSize was called, that is, it is
+	// capped by the maximum header table size.  If it is 0, the
+	// maximum header table size or DEFAULT_HEADER_TABLE_SIZE,
+	// whichever smaller, is used.
+	InitialTableSize uint
+	// Policy which decides how header field is indexed.  If it is
+	// nil, DefaultIndexingPolicy is used.
+	IndexingPolicy IndexingPolicy
+	// Policy which decides whether string is Huffman encoded.
+	HuffmanPolicy HuffmanPolicy
+	// Huffman policy for header fields keyed by lowercase name,
+	// which overrides HuffmanPolicy for both name and value.  For
+	// example, HuffmanNever can be used for secret values.
+	HuffmanNames map[string]HuffmanPolicy
+	// Maximum header list size the peer accepts.  If it is 0, there
+	// is no limit.  See SetMaxHeaderListSize.
+	MaxHeaderListSize uint
+	// Validator which checks header fields before encoding.  See
+	// SetValidator.
+	Validator *Validator
+	// If it is not nil, header fields it detects as sensitive are
+	// emitted as never indexed literals, as if Header.NeverIndex is
+	// true.
+	SensitiveHeaders *SensitiveHeaders
+	// If it is true, cookie header field is split into separate
+	// header fields for each cookie-pair before encoding, so that
+	// each of them is indexed individually (RFC 7540 Section
+	// 8.1.2.5).  The maximum header list size applies to the split
+	// header fields.
+	SplitCookies bool
+}
+
+// SensitiveHeaders detects sensitive header fields, which are
+// vulnerable to CRIME-style attacks if they are indexed (RFC 7541
+// Section 7.1.3).
+type SensitiveHeaders struct {
+	// Lowercase names of header fields which are always
+	// sensitive.
+	Names []string
+	// cookie header field of which value is shorter than this is
+	// sensitive, since it is relatively easy to guess.  If it is 0,
+	// cookie header field is not sensitive unless it is in Names.
+	ShortCookieLength int
+}
+
+// NewSensitiveHeaders returns SensitiveHeaders which detects
+// authorization, proxy-authorization and cookie shorter than 20
+// bytes.
+func NewSensitiveHeaders() *SensitiveHeaders {
+	return &SensitiveHeaders{
+		Names:             []string{"authorization", "proxy-authorization"},
+		ShortCookieLength: 20,
+	}
+}
+
+// Sensitive returns true if header is sensitive.
+func (s *SensitiveHeaders) Sensitive(header *Header) bool {
+	for _, name := range s.Names {
+		if ctstreq(header.Name, name) {
+			return true
+		}
+	}
+
+	return len(header.Value) < s.ShortCookieLength &&
+		ctstreq(header.Name, "cookie")
+}
+
+// Indexing is the representation of header field the encoder
+// emits.
+type Indexing int
+
+const (
+	// Literal header field with incremental indexing.  The header
+	// field is inserted into the header table.
+	IndexingIncremental Indexing = iota
+	// Literal header field without indexing.
+	IndexingNone
+	// Literal header field never indexed.  Intermediaries must use
+	// the same representation when they forward the header field.
+	IndexingNever
+)
+
+// IndexingPolicy decides how header field is indexed.  The encoder
+// calls Indexing for each header field, unless Header.NeverIndex is
+// true, in which case the header field is always never indexed.
+// Even if Indexing returns IndexingIncremental or IndexingNone, the
+// encoder uses indexed representation if the header table has the
+// same header field.
+type IndexingPolicy interface {
+	Indexing(header *Header) Indexing
+}
+
+type defaultIndexingPolicy struct{}
+
+func (defaultIndexingPolicy) Indexing(header *Header) Indexing {
+	if ctstreq(header.Name, "set-cookie") ||
+		ctstreq(header.Name, "content-length") ||
+		ctstreq(header.Name, "location") ||
+		ctstreq(header.Name, "etag") ||
+		ctstreq(header.Name, ":path") {
+		return IndexingNone
+	}
+
+	return IndexingIncremental
+}
+
+// DefaultIndexingPolicy indexes all header fields except for
+// set-cookie, content-length, location, etag and :path, of which
+// value is likely to change for each request or response.
+var DefaultIndexingPolicy IndexingPolicy = defaultIndexingPolicy{}
+
+// NameSetIndexingPolicy decides how header field is indexed by its
+// name.  For example, it can index :path, which DefaultIndexingPolicy
+// does not, or avoid indexing per-request identifiers like
+// x-request-id.
+type NameSetIndexingPolicy struct {
+	// Indexing for header fields keyed by lowercase name.
+	Names map[string]Indexing
+	// Policy for header fields of which name is not in Names.  If
+	// it is nil, DefaultIndexingPolicy is used.
+	Next IndexingPolicy
+}
+
+func (p *NameSetIndexingPolicy) Indexing(header *Header) Indexing {
+	if indexing, ok := p.Names[header.Name]; ok {
+		return indexing
+	}
+
+	return nextIndexing(p.Next, header)
+}
+
+// SizeThresholdIndexingPolicy does not index header fields larger
+// than MaxSize, so that they do not evict many entries from the
+// header table.  It emits them as literal header field without
+// indexing, unless Next decides that they are never indexed.  The
+// size of header field is the sum of the length of name and value
+// plus 32, as defined in RFC 7541 Section 4.1.
+type SizeThresholdIndexingPolicy struct {
+	// Maximum size of header field to index.
+	MaxSize uint
+	// Policy for header fields not larger than MaxSize.  If it is
+	// nil, DefaultIndexingPolicy is used.
+	Next IndexingPolicy
+}
+
+func (p *SizeThresholdIndexingPolicy) Indexing(header *Header) Indexing {
+	indexing := nextIndexing(p.Next, header)
+
+	if indexing == IndexingIncremental && headerSize(header) > p.MaxSize {
+		return IndexingNone
+	}
+
+	return indexing
+}
+
+func nextIndexing(next IndexingPolicy, header *Header) Indexing {
+	if next == nil {
+		next = DefaultIndexingPolicy
+	}
+
+	return next.Indexing(header)
+}
+
+// HuffmanPolicy decides whether string is Huffman encoded.
+type HuffmanPolicy int
+
+const (
+	// For Encoder, the same as HuffmanShortest.  In
+	// EncoderOptions.HuffmanNames, the policy of Encoder is used.
+	HuffmanDefault HuffmanPolicy = iota
+	// Huffman encode string if it is shorter than the raw
+	// string.
+	HuffmanShortest
+	// Always Huffman encode string, even if it gets longer.
+	HuffmanAlways
+	// Never Huffman encode string.  This is useful for debugging,
+	// and for secret values to avoid leaking their length through
+	// the compression ratio.
+	HuffmanNever
+)
+
 // NewEncoder returns new HPACK encoder.  encoderMaxTableSize
-// specifies the maximum header table size this encoder supports.
+// specifies the maximum header table size this encoder supports.  If
+// it is 0, dynamic table is not used.
 func NewEncoder(encoderMaxTableSize uint) *Encoder {
+	return NewEncoderWithOptions(EncoderOptions{
+		MaxTableSize:   encoderMaxTableSize,
+		NoDynamicTable: encoderMaxTableSize == 0,
+	})
+}
+
+// NewEncoderWithOptions returns new HPACK encoder configured by opts.
+func NewEncoderWithOptions(opts EncoderOptions) *Encoder {
 	var contextUpdate bool
 	var maxTableSize uint
 
+	encoderMaxTableSize := opts.MaxTableSize
+
+	switch {
+	case opts.NoDynamicTable:
+		encoderMaxTableSize = 0
+	case encoderMaxTableSize == 0:
+		encoderMaxTableSize = DEFAULT_HEADER_TABLE_SIZE
+	}
+
 	if encoderMaxTableSize < DEFAULT_HEADER_TABLE_SIZE {
 		contextUpdate = true
 		maxTableSize = encoderMaxTableSize
@@ -55,16 +291,110 @@ func NewEncoder(encoderMaxTableSize uint) *Encoder {
 		maxTableSize = DEFAULT_HEADER_TABLE_SIZE
 	}
 
+	indexingPolicy := opts.IndexingPolicy
+	if indexingPolicy == nil {
+		indexingPolicy = DefaultIndexingPolicy
+	}
+
+	maxHeaderListSize := opts.MaxHeaderListSize
+	if maxHeaderListSize == 0 {
+		maxHeaderListSize = uintMax
+	}
+
+	ht := newHeaderTable(maxTableSize)
+	ht.buildIndex()
+
 	encoder := &Encoder{
-		newHeaderTable(maxTableSize),
-		encoderMaxTableSize, uint32Max, contextUpdate,
+		ht,
+		encoderMaxTableSize, uint32Max, contextUpdate, maxHeaderListSize,
+		opts.Validator, nil, indexingPolicy, opts.HuffmanPolicy,
+		opts.HuffmanNames, opts.SensitiveHeaders, opts.SplitCookies,
+	}
+
+	if opts.InitialTableSize != 0 && !opts.NoDynamicTable {
+		encoder.ChangeTableSize(opts.InitialTableSize)
 	}
 
 	return encoder
 }
 
-// Encode headers and write the output to dst.
-func (enc *Encoder) Encode(dst *bytes.Buffer, headers []*Header) {
+// Set the maximum header list size the peer accepts to n.  This is
+// the value of SETTINGS_MAX_HEADER_LIST_SIZE the peer advertised.  By
+// default, there is no limit.
+func (enc *Encoder) SetMaxHeaderListSize(n uint) {
+	enc.maxHeaderListSize = n
+}
+
+// Set validator which checks header fields against HTTP/2 semantic
+// rules before encoding.  If v is nil, header fields are not
+// validated.  Encoder only calls v.Validate, so that v can be shared
+// with other Encoders.
+func (enc *Encoder) SetValidator(v *Validator) {
+	enc.validator = v
+}
+
+// Encode headers and write the output to dst.  If the size of headers
+// exceeds the maximum header list size, this function returns
+// ErrHeaderListTooLarge without writing anything to dst.  Similarly,
+// if validator is set and headers are malformed, this function returns
+// *MalformedError.
+func (enc *Encoder) Encode(dst *bytes.Buffer, headers []*Header) error {
+	b, err := enc.AppendEncode(dst.AvailableBuffer(), headers)
+
+	if err != nil {
+		return err
+	}
+
+	dst.Write(b)
+
+	return nil
+}
+
+// EncodeTo encodes headers and writes the output to w in one Write
+// call.  The errors are the same as Encode, plus the error returned
+// from w.  If writing to w fails, the compression context has been
+// updated regardless, and it is no longer in sync with the peer.
+func (enc *Encoder) EncodeTo(w io.Writer, headers []*Header) error {
+	b, err := enc.AppendEncode(enc.buf[:0], headers)
+
+	if err != nil {
+		return err
+	}
+
+	enc.buf = b
+
+	_, err = w.Write(b)
+
+	return err
+}
+
+// AppendEncode encodes headers, appends the output to dst and returns
+// the extended buffer.  The errors are the same as Encode.  On error,
+// dst is returned unchanged.
+func (enc *Encoder) AppendEncode(dst []byte, headers []*Header) ([]byte, error) {
+	if enc.validator != nil {
+		if err := enc.validator.Validate(headers); err != nil {
+			return dst, err
+		}
+	}
+
+	if enc.splitCookies {
+		headers = splitCookies(headers)
+	}
+
+	var headerListSize uint
+
+	for _, header := range headers {
+		size := headerSize(header)
+
+		if size > enc.maxHeaderListSize ||
+			headerListSize > enc.maxHeaderListSize-size {
+			return dst, ErrHeaderListTooLarge
+		}
+
+		headerListSize += size
+	}
+
 	if enc.contextUpdate {
 		settingsMinTableSize := enc.settingsMinTableSize
 
@@ -72,58 +402,60 @@ func (enc *Encoder) Encode(dst *bytes.Buffer, headers []*Header) {
 		enc.settingsMinTableSize = uint32Max
 
 		if settingsMinTableSize < enc.ht.maxTableSize {
-			encodeTableSize(dst, settingsMinTableSize)
+			dst = appendTableSize(dst, settingsMinTableSize)
 		}
 
-		encodeTableSize(dst, enc.ht.maxTableSize)
+		dst = appendTableSize(dst, enc.ht.maxTableSize)
 	}
 
 	for _, header := range headers {
-		enc.encodeHeader(dst, header)
+		dst = enc.appendHeader(dst, header)
 	}
+
+	return dst, nil
 }
 
-func (enc *Encoder) encodeHeader(dst *bytes.Buffer, header *Header) {
+func (enc *Encoder) appendHeader(dst []byte, header *Header) []byte {
+	var indexing Indexing
+
+	if header.NeverIndex || (enc.sensitiveHeaders != nil &&
+		enc.sensitiveHeaders.Sensitive(header)) {
+		indexing = IndexingNever
+	} else {
+		indexing = enc.indexingPolicy.Indexing(header)
+	}
+
+	neverIndexing := indexing == IndexingNever
+
 	idx, nameValueMatch := enc.ht.Search(header.Name, header.Value,
-		header.NeverIndex)
+		neverIndexing)
 
 	if nameValueMatch {
-		encodeIndex(dst, idx)
-
-		return
+		return appendIndex(dst, idx)
 	}
 
-	var indexing bool
-
-	if enc.shouldIndexing(header) {
-		indexing = true
+	// Header field which does not fit in the table is not indexed.
+	// Otherwise, the decoder would just empty its table.
+	incremental := indexing == IndexingIncremental &&
+		headerSize(header) <= enc.ht.maxTableSize
 
-		var entry *headerTableEntry
-		if idx == -1 {
-			entry = newHeaderTableEntry(header)
-		} else {
-			entry = newHeaderTableEntry(header)
-		}
+	if incremental {
+		entry := newHeaderTableEntry(header)
 		enc.ht.PushFront(entry)
-	} else {
-		indexing = false
+	}
+
+	huffman := enc.huffmanPolicy
+	if p := enc.huffmanNames[header.Name]; p != HuffmanDefault {
+		huffman = p
 	}
 
 	if idx == -1 {
-		encodeNewname(dst, header.Name, header.Value,
-			indexing, header.NeverIndex)
-	} else {
-		encodeIndname(dst, idx, header.Value,
-			indexing, header.NeverIndex)
+		return appendNewname(dst, header.Name, header.Value,
+			incremental, neverIndexing, huffman)
 	}
-}
 
-func (enc *Encoder) shouldIndexing(header *Header) bool {
-	return !ctstreq(header.Name, "set-cookie") &&
-		!ctstreq(header.Name, "content-length") &&
-		!ctstreq(header.Name, "location") &&
-		!ctstreq(header.Name, "etag") &&
-		!ctstreq(header.Name, ":path")
+	return appendIndname(dst, idx, header.Value,
+		incremental, neverIndexing, huffman)
 }
 
 // Change maximum header table size to n.
@@ -141,25 +473,15 @@ func (enc *Encoder) ChangeTableSize(n uint) {
 	enc.ht.ChangeTableSize(n)
 }
 
-func encodeTableSize(dst *bytes.Buffer, tableSize uint) {
-	head := dst.Len()
-
-	encodeInteger(dst, uint64(tableSize), 5)
-
-	dst.Bytes()[head] |= 0x20
+func appendTableSize(dst []byte, tableSize uint) []byte {
+	return appendInteger(dst, 0x20, uint64(tableSize), 5)
 }
 
-func encodeIndex(dst *bytes.Buffer, idx int) {
-	head := dst.Len()
-
-	encodeInteger(dst, uint64(idx+1), 7)
-
-	dst.Bytes()[head] |= 0x80
+func appendIndex(dst []byte, idx int) []byte {
+	return appendInteger(dst, 0x80, uint64(idx+1), 7)
 }
 
-func encodeIndname(dst *bytes.Buffer, idx int, value string, indexing bool, neverIndexing bool) {
-	head := dst.Len()
-
+func appendIndname(dst []byte, idx int, value string, indexing bool, neverIndexing bool, huffman HuffmanPolicy) []byte {
 	var prefix uint
 	if indexing {
 		prefix = 6
@@ -167,17 +489,16 @@ func encodeIndname(dst *bytes.Buffer, idx int, value string, indexing bool, neve
 		prefix = 4
 	}
 
-	encodeInteger(dst, uint64(idx+1), prefix)
-
-	dst.Bytes()[head] |= packFirstByte(indexing, neverIndexing)
+	dst = appendInteger(dst, packFirstByte(indexing, neverIndexing),
+		uint64(idx+1), prefix)
 
-	encodeString(dst, value)
+	return appendString(dst, value, huffman)
 }
 
-func encodeNewname(dst *bytes.Buffer, name string, value string, indexing bool, neverIndexing bool) {
-	dst.WriteByte(packFirstByte(indexing, neverIndexing))
-	encodeString(dst, name)
-	encodeString(dst, value)
+func appendNewname(dst []byte, name string, value string, indexing bool, neverIndexing bool, huffman HuffmanPolicy) []byte {
+	dst = append(dst, packFirstByte(indexing, neverIndexing))
+	dst = appendString(dst, name, huffman)
+	return appendString(dst, value, huffman)
 }
 
 func packFirstByte(indexing bool, neverIndexing bool) byte {
@@ -192,43 +513,46 @@ func packFirstByte(indexing bool, neverIndexing bool) byte {
 	return 0
 }
 
-func encodeInteger(dst *bytes.Buffer, n uint64, prefix uint) {
+// Append n encoded as integer with prefix bits prefix.  The bits of
+// first other than prefix are ORed into the first byte.
+func appendInteger(dst []byte, first byte, n uint64, prefix uint) []byte {
 	k := uint64((1 << prefix) - 1)
 
 	if n < k {
-		dst.WriteByte(byte(n))
-		return
+		return append(dst, first|byte(n))
 	}
 
-	dst.WriteByte(byte(k))
+	dst = append(dst, first|byte(k))
 
 	n -= k
 
 	for {
 		if n < 128 {
-			dst.WriteByte(byte(n))
+			dst = append(dst, byte(n))
 			break
 		}
 
-		dst.WriteByte(byte(0x80 | (n & 0x7f)))
+		dst = append(dst, byte(0x80|(n&0x7f)))
 		n >>= 7
 
 		if n == 0 {
 			break
 		}
 	}
+
+	return dst
 }
 
-func encodeString(dst *bytes.Buffer, src string) {
-	huffmanLength := HuffmanEncodeLength(src)
-	head := dst.Len()
+func appendString(dst []byte, src string, huffman HuffmanPolicy) []byte {
+	if huffman != HuffmanNever {
+		huffmanLength := HuffmanEncodeLength(src)
 
-	if huffmanLength < len(src) {
-		encodeInteger(dst, uint64(huffmanLength), 7)
-		HuffmanEncode(dst, src)
-		dst.Bytes()[head] |= 0x80
-	} else {
-		encodeInteger(dst, uint64(len(src)), 7)
-		dst.WriteString(src)
+		if huffmanLength < len(src) || huffman == HuffmanAlways {
+			dst = appendInteger(dst, 0x80, uint64(huffmanLength), 7)
+			return AppendHuffmanEncode(dst, src)
+		}
 	}
+
+	dst = appendInteger(dst, 0, uint64(len(src)), 7)
+	return append(dst, src...)
 }
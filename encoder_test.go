@@ -25,6 +25,7 @@ package hpack
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"reflect"
 	"testing"
@@ -86,9 +87,11 @@ func TestEncoderChangeTableSize(t *testing.T) {
 			dec.settingsMaxTableSize, 8192)
 	}
 
-	if dec.ht.maxTableSize != 8192 {
+	// Decoder keeps the smallest size until encoder sends dynamic
+	// table size update.
+	if dec.ht.maxTableSize != 0 {
 		t.Errorf("dec.ht.maxTableSize = %v, want %v",
-			dec.ht.maxTableSize, 8192)
+			dec.ht.maxTableSize, 0)
 	}
 
 	encoded.Reset()
@@ -113,6 +116,39 @@ func TestEncoderChangeTableSize(t *testing.T) {
 	}
 }
 
+func TestEncoderOversizedEntry(t *testing.T) {
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	dec := NewDecoder()
+
+	enc.ChangeTableSize(0)
+	dec.ChangeTableSize(0)
+
+	nva := []*Header{NewHeader("user-agent", "curl", false)}
+
+	// The field does not fit in the table, so it is not indexed.
+	for _, expected := range []string{"200f2b8325b651", "0f2b8325b651"} {
+		encoded := &bytes.Buffer{}
+
+		if err := enc.Encode(encoded, nva); err != nil {
+			t.Fatalf("enc.Encode(...) returned error %v", err)
+		}
+
+		if hex.EncodeToString(encoded.Bytes()) != expected {
+			t.Errorf("enc.Encode(...) = %x, want %v",
+				encoded.Bytes(), expected)
+		}
+
+		if _, err := dec.DecodeFull(encoded.Bytes()); err != nil {
+			t.Errorf("dec.DecodeFull(...) returned error %v", err)
+		}
+
+		if enc.ht.tablelen != 0 {
+			t.Errorf("enc.ht.tablelen = %v, want %v",
+				enc.ht.tablelen, 0)
+		}
+	}
+}
+
 func TestEncoderEncode(t *testing.T) {
 	nva1 := []*Header{
 		&Header{":status", "301", false},
@@ -148,6 +184,501 @@ func TestEncoderEncode(t *testing.T) {
 	encodeDecode(t, enc, dec, nva2)
 }
 
+func TestEncoderMaxHeaderListSize(t *testing.T) {
+	nva := []*Header{
+		// 5 + 5 + 32 = 42
+		&Header{"alpha", "bravo", false},
+		// 7 + 3 + 32 = 42
+		&Header{":method", "GET", false},
+	}
+
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	enc.SetMaxHeaderListSize(83)
+
+	encoded := &bytes.Buffer{}
+
+	err := enc.Encode(encoded, nva)
+
+	if err != ErrHeaderListTooLarge {
+		t.Errorf("enc.Encode(...) returned %v, want %v",
+			err, ErrHeaderListTooLarge)
+	}
+
+	if encoded.Len() != 0 {
+		t.Errorf("encoded.Len() = %v, want %v", encoded.Len(), 0)
+	}
+
+	if enc.ht.tablelen != 0 {
+		t.Errorf("enc.ht.tablelen = %v, want %v",
+			enc.ht.tablelen, 0)
+	}
+
+	enc.SetMaxHeaderListSize(84)
+
+	err = enc.Encode(encoded, nva)
+
+	if err != nil {
+		t.Errorf("enc.Encode(...) returned error %v", err)
+	}
+}
+
+func TestEncoderEncodeOctets(t *testing.T) {
+	all := make([]byte, 256)
+
+	for i := range all {
+		all[i] = byte(i)
+	}
+
+	nva := []*Header{
+		&Header{"x-octets", string(all), false},
+		&Header{"x-invalid-utf8", "\xff\xfe\xc3\x28", false},
+		&Header{"x-caf\xe9", "caf\xe9", false},
+	}
+
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	dec := NewDecoder()
+
+	encodeDecode(t, enc, dec, nva)
+	// Second time, header fields are encoded using indices.
+	encodeDecode(t, enc, dec, nva)
+}
+
+func TestEncoderAppendEncode(t *testing.T) {
+	nva := []*Header{
+		&Header{":method", "GET", false},
+		&Header{"alpha", "bravo", false},
+		&Header{"authorization", "basic aGVsbG86d29ybGQ=", true},
+	}
+
+	enc1 := NewEncoder(1024)
+	enc2 := NewEncoder(1024)
+	enc3 := NewEncoder(1024)
+
+	// Encode twice, so that the second block refers to dynamic
+	// table.
+	for i := 0; i < 2; i++ {
+		prefix := []byte("prefix")
+
+		appended, err := enc1.AppendEncode(prefix, nva)
+
+		if err != nil {
+			t.Fatalf("enc1.AppendEncode(...) returned error %v", err)
+		}
+
+		if !bytes.HasPrefix(appended, prefix) {
+			t.Errorf("enc1.AppendEncode(...) = %x, want prefix %x",
+				appended, prefix)
+		}
+
+		buffer := &bytes.Buffer{}
+
+		if err := enc2.Encode(buffer, nva); err != nil {
+			t.Fatalf("enc2.Encode(...) returned error %v", err)
+		}
+
+		writer := &bytes.Buffer{}
+
+		if err := enc3.EncodeTo(writer, nva); err != nil {
+			t.Fatalf("enc3.EncodeTo(...) returned error %v", err)
+		}
+
+		if !bytes.Equal(appended[len(prefix):], buffer.Bytes()) {
+			t.Errorf("enc1.AppendEncode(...) = %x, want %x",
+				appended[len(prefix):], buffer.Bytes())
+		}
+
+		if !bytes.Equal(writer.Bytes(), buffer.Bytes()) {
+			t.Errorf("enc3.EncodeTo(...) wrote %x, want %x",
+				writer.Bytes(), buffer.Bytes())
+		}
+	}
+
+	// dst is returned unchanged on error.
+	enc1.SetMaxHeaderListSize(1)
+
+	dst := []byte("prefix")
+
+	appended, err := enc1.AppendEncode(dst, nva)
+
+	if err != ErrHeaderListTooLarge {
+		t.Errorf("enc1.AppendEncode(...) returned %v, want %v",
+			err, ErrHeaderListTooLarge)
+	}
+
+	if !bytes.Equal(appended, dst) {
+		t.Errorf("enc1.AppendEncode(...) = %q, want %q", appended, dst)
+	}
+}
+
+type neverIndexingPolicy struct{}
+
+func (neverIndexingPolicy) Indexing(header *Header) Indexing {
+	return IndexingNever
+}
+
+func TestNewEncoderWithOptions(t *testing.T) {
+	nva := []*Header{
+		&Header{":method", "GET", false},
+		&Header{"alpha", "bravo", false},
+	}
+
+	// The zero value is the same as
+	// NewEncoder(DEFAULT_HEADER_TABLE_SIZE), and NoDynamicTable is
+	// the same as NewEncoder(0) regardless of the table sizes.
+	// Encode twice to see that the header table is used in the same
+	// way.
+	for _, test := range []struct {
+		opts         EncoderOptions
+		maxTableSize uint
+	}{
+		{EncoderOptions{}, DEFAULT_HEADER_TABLE_SIZE},
+		{EncoderOptions{MaxTableSize: 8192, NoDynamicTable: true}, 0},
+		{EncoderOptions{InitialTableSize: 1024, NoDynamicTable: true}, 0},
+	} {
+		expectedEnc := NewEncoder(test.maxTableSize)
+		enc := NewEncoderWithOptions(test.opts)
+
+		for i := 0; i < 2; i++ {
+			expected := &bytes.Buffer{}
+			encoded := &bytes.Buffer{}
+
+			expectedEnc.Encode(expected, nva)
+			enc.Encode(encoded, nva)
+
+			if !bytes.Equal(encoded.Bytes(), expected.Bytes()) {
+				t.Errorf("%+v: enc.Encode(...) = %x, want %x",
+					test.opts, encoded.Bytes(),
+					expected.Bytes())
+			}
+		}
+	}
+
+	// The zero value with other options uses the default table.
+	enc := NewEncoderWithOptions(EncoderOptions{
+		HuffmanPolicy: HuffmanNever,
+	})
+	dec := NewDecoder()
+
+	for i := 0; i < 2; i++ {
+		encoded := &bytes.Buffer{}
+
+		enc.Encode(encoded, nva)
+
+		if i == 1 && encoded.Len() != 2 {
+			t.Errorf("enc.Encode(...) = %x, want 2 indexed fields",
+				encoded.Bytes())
+		}
+
+		if _, err := dec.DecodeFull(encoded.Bytes()); err != nil {
+			t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+		}
+	}
+
+	encoded := &bytes.Buffer{}
+
+	enc = NewEncoderWithOptions(EncoderOptions{
+		MaxTableSize:      8192,
+		InitialTableSize:  8192,
+		IndexingPolicy:    neverIndexingPolicy{},
+		MaxHeaderListSize: 84,
+		Validator:         NewValidator(Request),
+	})
+
+	encoded.Reset()
+
+	if err := enc.Encode(encoded, nva); err == nil {
+		t.Errorf("enc.Encode(...) returned nil, want *MalformedError")
+	}
+
+	nva = []*Header{
+		&Header{":method", "GET", false},
+		&Header{":scheme", "https", false},
+		&Header{":path", "/", false},
+		&Header{"alpha", "bravo", false},
+	}
+
+	if err := enc.Encode(encoded, nva); err != ErrHeaderListTooLarge {
+		t.Errorf("enc.Encode(...) returned %v, want %v",
+			err, ErrHeaderListTooLarge)
+	}
+
+	enc.SetMaxHeaderListSize(uintMax)
+
+	if err := enc.Encode(encoded, nva); err != nil {
+		t.Fatalf("enc.Encode(...) returned error %v", err)
+	}
+
+	// Dynamic table size update of 8192 comes first.
+	if encoded.Bytes()[0] != 0x3f {
+		t.Errorf("encoded.Bytes()[0] = %#x, want %#x",
+			encoded.Bytes()[0], 0x3f)
+	}
+
+	if enc.ht.tablelen != 0 {
+		t.Errorf("enc.ht.tablelen = %v, want %v", enc.ht.tablelen, 0)
+	}
+
+	dec = NewDecoder()
+	dec.ChangeTableSize(8192)
+
+	headers, err := dec.DecodeFull(encoded.Bytes())
+
+	if err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	for i, header := range headers {
+		if !header.NeverIndex {
+			t.Errorf("headers[%v].NeverIndex = false, want true", i)
+		}
+	}
+}
+
+func TestEncoderNeverIndex(t *testing.T) {
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+
+	encoded := &bytes.Buffer{}
+
+	// Header.NeverIndex takes precedence over indexing policy.
+	enc.Encode(encoded, []*Header{
+		&Header{"authorization", "basic aGVsbG86d29ybGQ=", true},
+	})
+
+	// 0x1f 0x08: literal never indexed, indexed name 23.
+	if encoded.Bytes()[0] != 0x1f || encoded.Bytes()[1] != 0x08 {
+		t.Errorf("encoded.Bytes()[:2] = %x, want 1f08",
+			encoded.Bytes()[:2])
+	}
+
+	if enc.ht.tablelen != 0 {
+		t.Errorf("enc.ht.tablelen = %v, want %v", enc.ht.tablelen, 0)
+	}
+}
+
+func TestNameSetIndexingPolicy(t *testing.T) {
+	policy := &NameSetIndexingPolicy{
+		Names: map[string]Indexing{
+			":path":        IndexingIncremental,
+			"x-request-id": IndexingNone,
+			"traceparent":  IndexingNever,
+		},
+	}
+
+	tests := []struct {
+		header   *Header
+		expected Indexing
+	}{
+		{&Header{":path", "/", false}, IndexingIncremental},
+		{&Header{"x-request-id", "0123", false}, IndexingNone},
+		{&Header{"traceparent", "00-0123-01", false}, IndexingNever},
+		// Fall back to DefaultIndexingPolicy
+		{&Header{"etag", "\"xyzzy\"", false}, IndexingNone},
+		{&Header{"alpha", "bravo", false}, IndexingIncremental},
+	}
+
+	for _, test := range tests {
+		if indexing := policy.Indexing(test.header); indexing != test.expected {
+			t.Errorf("policy.Indexing(%v) = %v, want %v",
+				test.header, indexing, test.expected)
+		}
+	}
+}
+
+func TestSizeThresholdIndexingPolicy(t *testing.T) {
+	policy := &SizeThresholdIndexingPolicy{
+		// 5 + 5 + 32 = 42
+		MaxSize: 42,
+		Next: &NameSetIndexingPolicy{
+			Names: map[string]Indexing{"secret": IndexingNever},
+		},
+	}
+
+	tests := []struct {
+		header   *Header
+		expected Indexing
+	}{
+		{&Header{"alpha", "bravo", false}, IndexingIncremental},
+		{&Header{"alpha", "bravo!", false}, IndexingNone},
+		{&Header{"secret", "bravo!", false}, IndexingNever},
+		{&Header{":path", "/", false}, IndexingNone},
+	}
+
+	for _, test := range tests {
+		if indexing := policy.Indexing(test.header); indexing != test.expected {
+			t.Errorf("policy.Indexing(%v) = %v, want %v",
+				test.header, indexing, test.expected)
+		}
+	}
+
+	enc := NewEncoderWithOptions(EncoderOptions{
+		MaxTableSize:   DEFAULT_HEADER_TABLE_SIZE,
+		IndexingPolicy: policy,
+	})
+	dec := NewDecoder()
+
+	nva := make([]*Header, len(tests))
+
+	for i, test := range tests {
+		nva[i] = test.header
+	}
+
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, nva)
+
+	headers, err := dec.DecodeFull(encoded.Bytes())
+
+	if err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	if !headers[2].NeverIndex {
+		t.Errorf("headers[2].NeverIndex = false, want true")
+	}
+
+	if enc.ht.tablelen != 1 || dec.ht.tablelen != 1 {
+		t.Errorf("(enc.ht.tablelen, dec.ht.tablelen) = (%v, %v), want (%v, %v)",
+			enc.ht.tablelen, dec.ht.tablelen, 1, 1)
+	}
+}
+
+func TestEncoderHuffmanPolicy(t *testing.T) {
+	nvas := [][]*Header{
+		[]*Header{
+			NewHeader(":method", "GET", false),
+			NewHeader(":scheme", "http", false),
+			NewHeader(":path", "/", false),
+			NewHeader(":authority", "www.example.com", false),
+		},
+		[]*Header{
+			NewHeader(":method", "GET", false),
+			NewHeader(":scheme", "http", false),
+			NewHeader(":path", "/", false),
+			NewHeader(":authority", "www.example.com", false),
+			NewHeader("cache-control", "no-cache", false),
+		},
+		[]*Header{
+			NewHeader(":method", "GET", false),
+			NewHeader(":scheme", "https", false),
+			NewHeader(":path", "/index.html", false),
+			NewHeader(":authority", "www.example.com", false),
+			NewHeader("custom-key", "custom-value", false),
+		},
+	}
+
+	tests := []struct {
+		policy   HuffmanPolicy
+		expected []string
+	}{
+		// RFC 7541 C.3.  Requests without Huffman coding
+		{HuffmanNever, []string{
+			"828684410f7777772e6578616d706c652e636f6d",
+			"828684be58086e6f2d6361636865",
+			"828785bf400a637573746f6d2d6b65790c637573746f6d2d76616c7565",
+		}},
+		// RFC 7541 C.4.  Requests with Huffman coding
+		{HuffmanAlways, []string{
+			"828684418cf1e3c2e5f23a6ba0ab90f4ff",
+			"828684be5886a8eb10649cbf",
+			"828785bf408825a849e95ba97d7f8925a849e95bb8e8b4bf",
+		}},
+	}
+
+	for _, test := range tests {
+		enc := NewEncoderWithOptions(EncoderOptions{
+			MaxTableSize:  DEFAULT_HEADER_TABLE_SIZE,
+			HuffmanPolicy: test.policy,
+		})
+
+		for i, nva := range nvas {
+			encoded := &bytes.Buffer{}
+
+			enc.Encode(encoded, nva)
+
+			if actual := hex.EncodeToString(encoded.Bytes()); actual != test.expected[i] {
+				t.Errorf("enc.Encode(...) = %v, want %v",
+					actual, test.expected[i])
+			}
+		}
+	}
+
+	// HuffmanNames overrides the policy of Encoder.  Huffman
+	// encoded "1" is 1 byte, the same length as raw string.
+	enc := NewEncoderWithOptions(EncoderOptions{
+		MaxTableSize:  DEFAULT_HEADER_TABLE_SIZE,
+		HuffmanPolicy: HuffmanNever,
+		HuffmanNames: map[string]HuffmanPolicy{
+			"x-secret": HuffmanAlways,
+			"x-debug":  HuffmanDefault,
+		},
+	})
+
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, []*Header{
+		NewHeader("x-secret", "1", false),
+		NewHeader("x-debug", "1", false),
+	})
+
+	if actual := hex.EncodeToString(encoded.Bytes()); actual != "4086f2b20a4b0a9f810f4007782d64656275670131" {
+		t.Errorf("enc.Encode(...) = %v, want %v",
+			actual, "4086f2b20a4b0a9f810f4007782d64656275670131")
+	}
+}
+
+func TestEncoderSensitiveHeaders(t *testing.T) {
+	enc := NewEncoderWithOptions(EncoderOptions{
+		MaxTableSize:     DEFAULT_HEADER_TABLE_SIZE,
+		SensitiveHeaders: NewSensitiveHeaders(),
+	})
+	dec := NewDecoder()
+
+	nva := []*Header{
+		NewHeader("authorization", "basic aGVsbG86d29ybGQ=", false),
+		NewHeader("proxy-authorization", "basic aGVsbG86d29ybGQ=", false),
+		// 19 bytes
+		NewHeader("cookie", "SID=31d4d96e407aad4", false),
+		// 20 bytes
+		NewHeader("cookie", "SID=31d4d96e407aad42", false),
+		&Header{"alpha", "bravo", false},
+	}
+
+	expected := []bool{true, true, true, false, false}
+
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, nva)
+
+	headers, err := dec.DecodeFull(encoded.Bytes())
+
+	if err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	for i, header := range headers {
+		if header.NeverIndex != expected[i] {
+			t.Errorf("headers[%v].NeverIndex = %v, want %v",
+				i, header.NeverIndex, expected[i])
+		}
+	}
+
+	// Only the last 2 header fields are indexed.
+	if enc.ht.tablelen != 2 {
+		t.Errorf("enc.ht.tablelen = %v, want %v", enc.ht.tablelen, 2)
+	}
+
+	// Custom configuration
+	sensitive := &SensitiveHeaders{Names: []string{"x-api-key"}}
+
+	if !sensitive.Sensitive(NewHeader("x-api-key", "secret", false)) {
+		t.Errorf("sensitive.Sensitive(x-api-key) = false, want true")
+	}
+
+	if sensitive.Sensitive(NewHeader("cookie", "a=b", false)) {
+		t.Errorf("sensitive.Sensitive(cookie) = true, want false")
+	}
+}
+
 func encodeDecode(t *testing.T, enc *Encoder, dec *Decoder, src []*Header) {
 	encoded := &bytes.Buffer{}
 
@@ -0,0 +1,226 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrCode is HTTP/2 error code defined in RFC 7540 section 7.
+type ErrCode uint32
+
+const (
+	// PROTOCOL_ERROR
+	ErrCodeProtocol ErrCode = 0x1
+	// COMPRESSION_ERROR
+	ErrCodeCompression ErrCode = 0x9
+)
+
+func (c ErrCode) String() string {
+	switch c {
+	case ErrCodeProtocol:
+		return "PROTOCOL_ERROR"
+	case ErrCodeCompression:
+		return "COMPRESSION_ERROR"
+	}
+
+	return fmt.Sprintf("unknown error code 0x%x", uint32(c))
+}
+
+var (
+	// ErrIndexTooLarge is returned when index refers to neither
+	// static table nor dynamic table.
+	ErrIndexTooLarge = errors.New("index is too large")
+	// ErrIndexZero is returned when index is 0.
+	ErrIndexZero = errors.New("illegal index = 0")
+	// ErrIntegerOverflow is returned when integer representation
+	// does not fit in 32 bits.
+	ErrIntegerOverflow = errors.New("integer overflow")
+	// ErrTableSizeTooLarge is returned when dynamic table size
+	// update exceeds the maximum header table size.
+	ErrTableSizeTooLarge = errors.New("header table size is too large")
+	// ErrInputEndedPrematurely is returned when header block ends
+	// in the middle of representation.
+	ErrInputEndedPrematurely = errors.New("input ended prematurely")
+	// ErrTableSizeUpdateAfterHeader is returned when dynamic table
+	// size update appears after header field representation in a
+	// header block.
+	ErrTableSizeUpdateAfterHeader = errors.New(
+		"dynamic table size update after header field")
+	// ErrTooManyTableSizeUpdates is returned when a header block
+	// contains more than two dynamic table size updates.
+	ErrTooManyTableSizeUpdates = errors.New(
+		"too many dynamic table size updates")
+	// ErrTableSizeUpdateRequired is returned when a header block
+	// following the reduction of maximum header table size does
+	// not start with dynamic table size update which acknowledges
+	// it.
+	ErrTableSizeUpdateRequired = errors.New(
+		"dynamic table size update required")
+	// ErrHuffmanEOS is returned when Huffman-encoded string
+	// contains EOS symbol.
+	ErrHuffmanEOS = errors.New("Huffman-encoded string contains EOS")
+	// ErrHuffmanPaddingTooLong is returned when the padding of
+	// Huffman-encoded string is longer than 7 bits.
+	ErrHuffmanPaddingTooLong = errors.New(
+		"Huffman padding is longer than 7 bits")
+	// ErrHuffmanInvalidPadding is returned when the padding of
+	// Huffman-encoded string is not the most significant bits of
+	// EOS.  This happens when the string ends in the middle of
+	// code.
+	ErrHuffmanInvalidPadding = errors.New(
+		"Huffman padding is not the most significant bits of EOS")
+	// ErrHeaderListTooLarge is returned when the size of header
+	// list exceeds the maximum header list size.  The size of
+	// header list is the sum of the length of header name and
+	// value plus 32 for each header field, as defined for
+	// SETTINGS_MAX_HEADER_LIST_SIZE in RFC 7540.
+	ErrHeaderListTooLarge = errors.New("header list is too large")
+	// ErrMidBlock is returned when Decoder.MarshalBinary is called
+	// in the middle of header block.
+	ErrMidBlock = errors.New("decoder is in the middle of header block")
+	// ErrInvalidState is returned when UnmarshalBinary is given
+	// data which is not produced by MarshalBinary of the same
+	// type.
+	ErrInvalidState = errors.New("invalid serialized state")
+)
+
+// Instruction is the kind of HPACK representation.
+type Instruction int
+
+const (
+	// The kind of representation is not known yet.
+	InstructionNone Instruction = iota
+	// Indexed header field representation.
+	InstructionIndexed
+	// Literal header field representation with new name.
+	InstructionLiteralNewName
+	// Literal header field representation with indexed name.
+	InstructionLiteralIndexedName
+	// Dynamic table size update.
+	InstructionTableSizeUpdate
+)
+
+var instructionNames = []string{
+	"none",
+	"indexed",
+	"literal with new name",
+	"literal with indexed name",
+	"dynamic table size update",
+}
+
+func (ins Instruction) String() string {
+	if ins < 0 || int(ins) >= len(instructionNames) {
+		return fmt.Sprintf("unknown instruction %d", int(ins))
+	}
+
+	return instructionNames[ins]
+}
+
+func instructionOf(opcode int) Instruction {
+	switch opcode {
+	case opcodeIndexed:
+		return InstructionIndexed
+	case opcodeNewname:
+		return InstructionLiteralNewName
+	case opcodeIndname:
+		return InstructionLiteralIndexedName
+	case opcodeTableSize:
+		return InstructionTableSizeUpdate
+	}
+
+	return InstructionNone
+}
+
+// Human readable names of decoder states, indexed by state.
+var stateNames = []string{
+	stateOpcode:        "opcode",
+	stateReadTableSize: "table size",
+	stateReadIndex:     "index",
+	stateCheckNamelen:  "name length prefix",
+	stateReadNamelen:   "name length",
+	stateReadNamehuff:  "Huffman-encoded name",
+	stateReadName:      "name",
+	stateCheckValuelen: "value length prefix",
+	stateReadValuelen:  "value length",
+	stateReadValuehuff: "Huffman-encoded value",
+	stateReadValue:     "value",
+}
+
+// A DecodingError is returned by Decoder when it fails to decode
+// header block.  Use errors.Is to test which error occurred, for
+// example, errors.Is(err, ErrIndexTooLarge).
+type DecodingError struct {
+	// The offset in the header block where the error was detected.
+	Offset int
+	// The kind of representation being decoded.
+	Instruction Instruction
+	// The decoder state in which the error occurred.
+	State string
+	// The underlying error.
+	Err error
+}
+
+func (e *DecodingError) Error() string {
+	return fmt.Sprintf("hpack: decoding error at offset %v (%v, %v): %v",
+		e.Offset, e.Instruction, e.State, e.Err)
+}
+
+func (e *DecodingError) Unwrap() error {
+	return e.Err
+}
+
+// Code returns HTTP/2 error code which the connection should be
+// terminated with, which is always COMPRESSION_ERROR.  The decoder
+// cannot continue, and the compression context is lost.
+func (e *DecodingError) Code() ErrCode {
+	return ErrCodeCompression
+}
+
+// A HeaderListTooLargeError is returned by Decoder when the size of
+// header list exceeds the maximum header list size.  Like
+// MalformedError, this is a stream error: the rest of header block is
+// decoded, and the compression context is intact.  errors.Is(err,
+// ErrHeaderListTooLarge) reports true for it.
+type HeaderListTooLargeError struct {
+	// The maximum header list size.
+	MaxHeaderListSize uint
+}
+
+func (e *HeaderListTooLargeError) Error() string {
+	return fmt.Sprintf("hpack: header list is larger than %v",
+		e.MaxHeaderListSize)
+}
+
+func (e *HeaderListTooLargeError) Unwrap() error {
+	return ErrHeaderListTooLarge
+}
+
+// Code returns HTTP/2 error code which the stream should be reset
+// with, which is always PROTOCOL_ERROR.  A server may respond with 431
+// (Request Header Fields Too Large) status code instead.
+func (e *HeaderListTooLargeError) Code() ErrCode {
+	return ErrCodeProtocol
+}
@@ -74,6 +74,36 @@ func ExampleDecoder() {
 	// user-agent: nghttp2
 }
 
+func ExampleDecoder_DecodeFull() {
+	headers := []*hpack.Header{
+		hpack.NewHeader(":method", "GET", false),
+		hpack.NewHeader(":path", "/", false),
+		hpack.NewHeader("user-agent", "nghttp2", false),
+	}
+
+	enc := hpack.NewEncoder(hpack.DEFAULT_HEADER_TABLE_SIZE)
+	dec := hpack.NewDecoder()
+
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, headers)
+
+	decoded, err := dec.DecodeFull(encoded.Bytes())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, header := range decoded {
+		fmt.Printf("%s: %s\n", header.Name, header.Value)
+	}
+
+	// Output:
+	// :method: GET
+	// :path: /
+	// user-agent: nghttp2
+}
+
 func ExampleEncoder() {
 	headers := []*hpack.Header{
 		hpack.NewHeader(":method", "GET", false),
@@ -94,3 +124,46 @@ func ExampleEncoder() {
 	// Output:
 	// 828741882f91d35d055cf64d847a85aa69d29ac5
 }
+
+// hotPathPolicy indexes :path only for frequently requested
+// endpoints.
+type hotPathPolicy map[string]bool
+
+func (p hotPathPolicy) Indexing(header *hpack.Header) hpack.Indexing {
+	if header.Name == ":path" && p[header.Value] {
+		return hpack.IndexingIncremental
+	}
+
+	return hpack.DefaultIndexingPolicy.Indexing(header)
+}
+
+func ExampleNameSetIndexingPolicy() {
+	enc := hpack.NewEncoderWithOptions(hpack.EncoderOptions{
+		MaxTableSize: hpack.DEFAULT_HEADER_TABLE_SIZE,
+		IndexingPolicy: &hpack.NameSetIndexingPolicy{
+			Names: map[string]hpack.Indexing{
+				"x-request-id": hpack.IndexingNone,
+				"traceparent":  hpack.IndexingNone,
+			},
+			Next: hotPathPolicy{"/api/v1/status": true},
+		},
+	})
+
+	headers := []*hpack.Header{
+		hpack.NewHeader(":method", "GET", false),
+		hpack.NewHeader(":path", "/api/v1/status", false),
+		hpack.NewHeader("x-request-id", "0123456789abcdef", false),
+	}
+
+	for i := 0; i < 2; i++ {
+		encoded := &bytes.Buffer{}
+
+		enc.Encode(encoded, headers)
+
+		fmt.Println(encoded.Len())
+	}
+
+	// Output:
+	// 37
+	// 26
+}
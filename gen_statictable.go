@@ -0,0 +1,190 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build ignore
+
+// This program generates statictable.go from statictable.txt.  It is
+// invoked by "go generate".
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type entry struct {
+	name  string
+	value string
+}
+
+func readTable(path string) ([]entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []entry
+
+	scanner := bufio.NewScanner(f)
+
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%v:%v: malformed line", path, lineno)
+		}
+
+		index, err := strconv.Atoi(fields[0])
+		if err != nil || index != len(entries)+1 {
+			return nil, fmt.Errorf("%v:%v: index %v is out of order",
+				path, lineno, fields[0])
+		}
+
+		ent := entry{name: fields[1]}
+
+		if len(fields) == 3 {
+			ent.value = fields[2]
+		}
+
+		entries = append(entries, ent)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+const licenseHeader = `// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+`
+
+func generate(entries []entry) ([]byte, error) {
+	buf := &bytes.Buffer{}
+
+	buf.WriteString(licenseHeader)
+
+	fmt.Fprintf(buf, `
+// Code generated by gen_statictable.go from statictable.txt; DO NOT EDIT.
+
+package hpack
+
+// The static table defined in RFC 7541 Appendix A.
+var staticTable = []headerTableEntry{
+`)
+
+	for _, ent := range entries {
+		fmt.Fprintf(buf, "makeEntry(%q, %q),\n", ent.name, ent.value)
+	}
+
+	fmt.Fprintf(buf, `}
+
+// Map from header field name to the index of the first static table
+// entry which has that name.
+var staticNameIndex = map[string]int{
+`)
+
+	seen := make(map[string]bool)
+
+	for i, ent := range entries {
+		if seen[ent.name] {
+			continue
+		}
+
+		seen[ent.name] = true
+
+		fmt.Fprintf(buf, "%q: %v,\n", ent.name, i)
+	}
+
+	fmt.Fprintf(buf, `}
+
+// Map from header field name and value to the index of static table
+// entry.
+var staticNameValueIndex = map[staticTableKey]int{
+`)
+
+	for i, ent := range entries {
+		fmt.Fprintf(buf, "{%q, %q}: %v,\n", ent.name, ent.value, i)
+	}
+
+	fmt.Fprintf(buf, "}\n")
+
+	return format.Source(buf.Bytes())
+}
+
+func main() {
+	input := flag.String("input", "statictable.txt", "canonical table description")
+	output := flag.String("output", "statictable.go", "output file")
+
+	flag.Parse()
+
+	entries, err := readTable(*input)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	src, err := generate(entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.WriteFile(*output, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
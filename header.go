@@ -22,9 +22,14 @@
 // WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 
 // This package implements HTTP/2 HPACK encoder and decoder as defined
-// in http://http2.github.io/http2-spec/compression.html
+// in RFC 7541 (https://tools.ietf.org/html/rfc7541).
 package hpack
 
+import (
+	"encoding/binary"
+	"hash/maphash"
+)
+
 type Header struct {
 	// Header field name
 	Name string
@@ -39,19 +44,40 @@ func NewHeader(name, value string, neverIndex bool) *Header {
 	return &Header{name, value, neverIndex}
 }
 
+// Header table entry.  header is stored by value, so that header
+// fields passed to Encoder or returned from Decoder never alias the
+// header table.
 type headerTableEntry struct {
-	header    *Header
-	nameHash  uint32
-	valueHash uint32
+	header Header
+	// Seeded hashes of name, and of name and value.  They are set
+	// when entry is indexed by header table.
+	nameHash      uint64
+	nameValueHash uint64
+	// Absolute insertion counter of dynamic table entry.  This is
+	// unused for static table entries.
+	seq uint64
 }
 
 func newHeaderTableEntry(header *Header) *headerTableEntry {
-	nameHash := uint32hash(header.Name)
-	valueHash := uint32hash(header.Value)
-
-	return &headerTableEntry{header, nameHash, valueHash}
+	return &headerTableEntry{header: *header}
 }
 
+// Spec selects the revision of HPACK specification a Decoder
+// follows.
+type Spec int
+
+const (
+	// RFC 7541.  This is the default.
+	RFC7541 Spec = iota
+	// draft-ietf-httpbis-header-compression-09 as implemented by
+	// the earlier versions of this package.  The static table and
+	// the wire format of representations are the same as RFC 7541,
+	// but dynamic table size updates are accepted anywhere in a
+	// header block.  This is only useful to replay old test
+	// fixtures.
+	Draft09
+)
+
 const (
 	// Default header table size used for both encoder and
 	// decoder.
@@ -61,16 +87,13 @@ const (
 
 const (
 	uint32Max = uint(^uint32(0))
+	uintMax   = ^uint(0)
 )
 
-func uint32hash(s string) uint32 {
-	h := uint32(0)
-
-	for _, c := range s {
-		h = h*31 + uint32(c)
-	}
-
-	return h
+// Return the size of header field for the purpose of header list
+// size accounting.
+func headerSize(header *Header) uint {
+	return uint(len(header.Name)+len(header.Value)) + headerEntryOverhead
 }
 
 func ctstreq(a, b string) bool {
@@ -98,6 +121,20 @@ type headerTable struct {
 	first        uint
 	tableSize    uint
 	maxTableSize uint
+	// The number of entries ever inserted.  This is the insertion
+	// counter given to the next entry.  The entry with insertion
+	// counter n has dynamic table index seq-1-n.
+	seq uint64
+	// Indexes from name hash, and from name and value hash, to
+	// insertion counters of dynamic table entries, from the oldest
+	// to the newest.  They are nil unless buildIndex is called,
+	// since only Encoder searches dynamic table.
+	nameIndex      map[uint64][]uint64
+	nameValueIndex map[uint64][]uint64
+	// Random seed of the hashes above.  Each table has its own
+	// seed, so that the peer cannot craft header fields which
+	// collide in the indexes.
+	seed maphash.Seed
 }
 
 func newHeaderTable(maxTableSize uint) *headerTable {
@@ -108,7 +145,7 @@ func newHeaderTable(maxTableSize uint) *headerTable {
 	}
 
 	table := make([]*headerTableEntry, entryNum)
-	hdtable := &headerTable{table, 0, 0, 0, maxTableSize}
+	hdtable := &headerTable{table: table, maxTableSize: maxTableSize}
 
 	return hdtable
 }
@@ -141,8 +178,16 @@ func (ht *headerTable) ChangeTableSize(newSize uint) {
 	}
 }
 
+// Insert entry as the newest entry.  As RFC 7541 section 4.4 says, if
+// entry is larger than the maximum table size, the table is emptied
+// and entry is not inserted.
 func (ht *headerTable) PushFront(entry *headerTableEntry) {
 	ht.evictFor(entry)
+
+	if uint(entry.space()) > ht.maxTableSize {
+		return
+	}
+
 	ht.ensureCapcity()
 
 	ht.first--
@@ -151,6 +196,13 @@ func (ht *headerTable) PushFront(entry *headerTableEntry) {
 
 	ht.tablelen++
 	ht.tableSize += uint(entry.space())
+
+	entry.seq = ht.seq
+	ht.seq++
+
+	if ht.nameIndex != nil {
+		ht.indexEntry(entry)
+	}
 }
 
 func (ht *headerTable) PopBack() {
@@ -158,6 +210,71 @@ func (ht *headerTable) PopBack() {
 
 	ht.tableSize -= uint(entry.space())
 	ht.tablelen--
+
+	if ht.nameIndex != nil {
+		ht.unindexEntry(entry)
+	}
+}
+
+// Return seeded hash of name and value.  The length of name is hashed
+// too, so that moving octets between name and value changes the hash.
+func nameValueHash(seed maphash.Seed, name, value string) uint64 {
+	var h maphash.Hash
+	var namelen [8]byte
+
+	binary.LittleEndian.PutUint64(namelen[:], uint64(len(name)))
+
+	h.SetSeed(seed)
+	h.Write(namelen[:])
+	h.WriteString(name)
+	h.WriteString(value)
+
+	return h.Sum64()
+}
+
+// Make Search look up dynamic table entries through hash indexes.
+// The entries already in the table are indexed.
+func (ht *headerTable) buildIndex() {
+	ht.nameIndex = make(map[uint64][]uint64)
+	ht.nameValueIndex = make(map[uint64][]uint64)
+	ht.seed = maphash.MakeSeed()
+
+	for idx := ht.tablelen - 1; idx >= 0; idx-- {
+		ht.indexEntry(ht.dynget(idx))
+	}
+}
+
+func (ht *headerTable) indexEntry(entry *headerTableEntry) {
+	entry.nameHash = maphash.String(ht.seed, entry.header.Name)
+	entry.nameValueHash = nameValueHash(ht.seed, entry.header.Name,
+		entry.header.Value)
+
+	ht.nameIndex[entry.nameHash] =
+		append(ht.nameIndex[entry.nameHash], entry.seq)
+	ht.nameValueIndex[entry.nameValueHash] =
+		append(ht.nameValueIndex[entry.nameValueHash], entry.seq)
+}
+
+// Remove entry from indexes.  entry must be the oldest entry in the
+// table, and therefore it is the first one in both of its buckets.
+func (ht *headerTable) unindexEntry(entry *headerTableEntry) {
+	if seqs := ht.nameIndex[entry.nameHash]; len(seqs) == 1 {
+		delete(ht.nameIndex, entry.nameHash)
+	} else {
+		ht.nameIndex[entry.nameHash] = seqs[1:]
+	}
+
+	if seqs := ht.nameValueIndex[entry.nameValueHash]; len(seqs) == 1 {
+		delete(ht.nameValueIndex, entry.nameValueHash)
+	} else {
+		ht.nameValueIndex[entry.nameValueHash] = seqs[1:]
+	}
+}
+
+// Return the dynamic table index of the entry with insertion counter
+// seq.
+func (ht *headerTable) seqIndex(seq uint64) int {
+	return int(ht.seq - 1 - seq)
 }
 
 func (ht *headerTable) dynget(idx int) *headerTableEntry {
@@ -183,120 +300,73 @@ func (ht *headerTable) Get(idx int) *headerTableEntry {
 	return &staticTable[idx]
 }
 
+// Search static table, and then dynamic table for name and value.
+// Static table is looked up through the maps generated from
+// statictable.txt.  Dynamic table is looked up through hash indexes,
+// which requires buildIndex.
 func (ht *headerTable) Search(name string, value string, noNameValueMatch bool) (index int, nameValueMatch bool) {
 	index = -1
 
-	nameHash := uint32hash(name)
-	valueHash := uint32hash(value)
-
-	for idx, entry := range staticTable {
-		if nameHash == entry.nameHash && ctstreq(name, entry.header.Name) {
-			if index == -1 {
-				index = idx
-			}
-
-			if !noNameValueMatch &&
-				valueHash == entry.valueHash &&
-				ctstreq(value, entry.header.Value) {
+	if idx, ok := staticNameIndex[name]; ok {
+		index = idx
 
-				index = idx
-				nameValueMatch = true
-				return
+		if !noNameValueMatch {
+			if idx, ok := staticNameValueIndex[staticTableKey{name, value}]; ok {
+				return idx, true
 			}
 		}
 	}
 
-	if noNameValueMatch {
+	if noNameValueMatch || ht.nameIndex == nil {
 		return
 	}
 
-	for idx := 0; idx < ht.tablelen; idx++ {
+	// Search buckets from the newest entry, so that the smallest
+	// index is returned.  Hashes only select candidates; they are
+	// compared in constant time.
+	seqs := ht.nameValueIndex[nameValueHash(ht.seed, name, value)]
+
+	for i := len(seqs) - 1; i >= 0; i-- {
+		idx := ht.seqIndex(seqs[i])
 		entry := ht.dynget(idx)
 
-		if nameHash == entry.nameHash && ctstreq(name, entry.header.Name) {
-			if index == -1 {
-				index = idx + staticTableLength()
-			}
+		if ctstreq(name, entry.header.Name) &&
+			ctstreq(value, entry.header.Value) {
 
-			if !noNameValueMatch &&
-				valueHash == entry.valueHash &&
-				ctstreq(value, entry.header.Value) {
+			index = idx + staticTableLength()
+			nameValueMatch = true
+			return
+		}
+	}
 
-				index = idx + staticTableLength()
-				nameValueMatch = true
-				return
-			}
+	if index != -1 {
+		return
+	}
+
+	seqs = ht.nameIndex[maphash.String(ht.seed, name)]
+
+	for i := len(seqs) - 1; i >= 0; i-- {
+		idx := ht.seqIndex(seqs[i])
+
+		if ctstreq(name, ht.dynget(idx).header.Name) {
+			index = idx + staticTableLength()
+			return
 		}
 	}
+
 	return
 }
 
-func makeEntry(name, value string, nameHash, valueHash uint32) headerTableEntry {
-	return headerTableEntry{&Header{name, value, false}, nameHash, valueHash}
+//go:generate go run gen_statictable.go
+
+func makeEntry(name, value string) headerTableEntry {
+	return headerTableEntry{header: Header{Name: name, Value: value}}
 }
 
-var staticTable = []headerTableEntry{
-	makeEntry(":authority", "", 2962729033, 0),
-	makeEntry(":method", "GET", 3153018267, 70454),
-	makeEntry(":method", "POST", 3153018267, 2461856),
-	makeEntry(":path", "/", 56997727, 47),
-	makeEntry(":path", "/index.html", 56997727, 2144181430),
-	makeEntry(":scheme", "http", 3322585695, 3213448),
-	makeEntry(":scheme", "https", 3322585695, 99617003),
-	makeEntry(":status", "200", 3338091692, 49586),
-	makeEntry(":status", "204", 3338091692, 49590),
-	makeEntry(":status", "206", 3338091692, 49592),
-	makeEntry(":status", "304", 3338091692, 50551),
-	makeEntry(":status", "400", 3338091692, 51508),
-	makeEntry(":status", "404", 3338091692, 51512),
-	makeEntry(":status", "500", 3338091692, 52469),
-	makeEntry("accept-charset", "", 124285319, 0),
-	makeEntry("accept-encoding", "gzip, deflate", 4127597688, 1733326877),
-	makeEntry("accept-language", "", 802785917, 0),
-	makeEntry("accept-ranges", "", 1397189435, 0),
-	makeEntry("accept", "", 2871506184, 0),
-	makeEntry("access-control-allow-origin", "", 3297999203, 0),
-	makeEntry("age", "", 96511, 0),
-	makeEntry("allow", "", 92906313, 0),
-	makeEntry("authorization", "", 2909397113, 0),
-	makeEntry("cache-control", "", 4086191634, 0),
-	makeEntry("content-disposition", "", 3027699811, 0),
-	makeEntry("content-encoding", "", 2095084583, 0),
-	makeEntry("content-language", "", 3065240108, 0),
-	makeEntry("content-length", "", 3162187450, 0),
-	makeEntry("content-location", "", 2284906121, 0),
-	makeEntry("content-range", "", 2878374633, 0),
-	makeEntry("content-type", "", 785670158, 0),
-	makeEntry("cookie", "", 2940209764, 0),
-	makeEntry("date", "", 3076014, 0),
-	makeEntry("etag", "", 3123477, 0),
-	makeEntry("expect", "", 3005803609, 0),
-	makeEntry("expires", "", 2985731892, 0),
-	makeEntry("from", "", 3151786, 0),
-	makeEntry("host", "", 3208616, 0),
-	makeEntry("if-match", "", 34533653, 0),
-	makeEntry("if-modified-since", "", 2302095846, 0),
-	makeEntry("if-none-match", "", 646073760, 0),
-	makeEntry("if-range", "", 39145613, 0),
-	makeEntry("if-unmodified-since", "", 1454068927, 0),
-	makeEntry("last-modified", "", 150043680, 0),
-	makeEntry("link", "", 3321850, 0),
-	makeEntry("location", "", 1901043637, 0),
-	makeEntry("max-forwards", "", 1619948695, 0),
-	makeEntry("proxy-authenticate", "", 3993199572, 0),
-	makeEntry("proxy-authorization", "", 329532250, 0),
-	makeEntry("range", "", 108280125, 0),
-	makeEntry("referer", "", 1085069613, 0),
-	makeEntry("refresh", "", 1085444827, 0),
-	makeEntry("retry-after", "", 1933352567, 0),
-	makeEntry("server", "", 3389140803, 0),
-	makeEntry("set-cookie", "", 1237214767, 0),
-	makeEntry("strict-transport-security", "", 1153852136, 0),
-	makeEntry("transfer-encoding", "", 1274458357, 0),
-	makeEntry("user-agent", "", 486342275, 0),
-	makeEntry("vary", "", 3612210, 0),
-	makeEntry("via", "", 116750, 0),
-	makeEntry("www-authenticate", "", 4051929931, 0),
+// The key of staticNameValueIndex.
+type staticTableKey struct {
+	name  string
+	value string
 }
 
 func staticTableLength() int {
@@ -24,6 +24,8 @@
 package hpack
 
 import (
+	"fmt"
+	"hash/maphash"
 	"testing"
 )
 
@@ -52,7 +54,7 @@ func TestHeaderTablePushAndPop(t *testing.T) {
 		t.Errorf("ht.tablelen = %v, want %v", ht.tablelen, 2)
 	}
 
-	if ht.dynget(0).header != hd3 {
+	if ht.dynget(0).header != *hd3 {
 		t.Errorf("ht.Get(0).header = %v, want %v",
 			ht.Get(0).header, hd3)
 	}
@@ -87,13 +89,29 @@ func TestHeaderChangeTableSize(t *testing.T) {
 
 	header := ht.dynget(0).header
 
-	if header != hd2 {
+	if header != *hd2 {
 		t.Errorf("ht.dynget(0) = %v, want %v", header, hd2)
 	}
 }
 
+func TestHeaderTableOversizedEntry(t *testing.T) {
+	ht := newHeaderTable(64)
+
+	// 5 + 6 + 32 = 43
+	ht.PushFront(newHeaderTableEntry(NewHeader(":path", "/alpha", false)))
+	// 5 + 28 + 32 = 65
+	ht.PushFront(newHeaderTableEntry(NewHeader(":path",
+		"/alpha/bravo/charlie/delta/e", false)))
+
+	if ht.tablelen != 0 || ht.tableSize != 0 {
+		t.Errorf("(ht.tablelen, ht.tableSize) = (%v, %v), want (%v, %v)",
+			ht.tablelen, ht.tableSize, 0, 0)
+	}
+}
+
 func TestHeaderSearch(t *testing.T) {
 	ht := newHeaderTable(4096)
+	ht.buildIndex()
 
 	hd1 := &Header{":path", "/alpha", false}
 	hd2 := &Header{"bravo", "charlie", false}
@@ -143,3 +161,318 @@ func TestHeaderSearch(t *testing.T) {
 			idx, nameValueMatch, -1, false)
 	}
 }
+
+// Search dynamic table linearly.  This is the reference
+// implementation for the hash indexes.
+func linearDynamicSearch(ht *headerTable, name, value string) (index int, nameValueMatch bool) {
+	index = -1
+
+	for idx := 0; idx < ht.tablelen; idx++ {
+		entry := ht.dynget(idx)
+
+		if entry.header.Name != name {
+			continue
+		}
+
+		if entry.header.Value == value {
+			return idx, true
+		}
+
+		if index == -1 {
+			index = idx
+		}
+	}
+
+	return
+}
+
+func TestHeaderSearchEviction(t *testing.T) {
+	ht := newHeaderTable(256)
+	ht.buildIndex()
+
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("x-name%v", i%7)
+		value := fmt.Sprintf("v%v", i%5)
+
+		ht.PushFront(newHeaderTableEntry(NewHeader(name, value, false)))
+
+		if i%50 == 0 {
+			ht.ChangeTableSize(uint(64 + i))
+		}
+
+		for j := 0; j < 7; j++ {
+			for k := 0; k < 5; k++ {
+				name := fmt.Sprintf("x-name%v", j)
+				value := fmt.Sprintf("v%v", k)
+
+				idx, nameValueMatch := ht.Search(name, value, false)
+				wantIdx, wantMatch := linearDynamicSearch(ht, name, value)
+
+				if wantIdx != -1 {
+					wantIdx += staticTableLength()
+				}
+
+				if idx != wantIdx || nameValueMatch != wantMatch {
+					t.Fatalf("i = %v: ht.Search(%q, %q, false) = (%v, %v), want (%v, %v)",
+						i, name, value, idx, nameValueMatch,
+						wantIdx, wantMatch)
+				}
+			}
+		}
+	}
+
+	ht.ChangeTableSize(0)
+
+	if len(ht.nameIndex) != 0 || len(ht.nameValueIndex) != 0 {
+		t.Errorf("len(ht.nameIndex), len(ht.nameValueIndex) = %v, %v, want 0, 0",
+			len(ht.nameIndex), len(ht.nameValueIndex))
+	}
+}
+
+func TestHeaderTableBuildIndex(t *testing.T) {
+	ht := newHeaderTable(4096)
+
+	ht.PushFront(newHeaderTableEntry(NewHeader("alpha", "bravo", false)))
+	ht.PushFront(newHeaderTableEntry(NewHeader("alpha", "charlie", false)))
+
+	ht.buildIndex()
+
+	idx, nameValueMatch := ht.Search("alpha", "bravo", false)
+
+	if idx != staticTableLength()+1 || !nameValueMatch {
+		t.Errorf("(idx, nameValueMatch) = (%v, %v), want (%v, %v)",
+			idx, nameValueMatch, staticTableLength()+1, true)
+	}
+
+	idx, nameValueMatch = ht.Search("alpha", "delta", false)
+
+	if idx != staticTableLength() || nameValueMatch {
+		t.Errorf("(idx, nameValueMatch) = (%v, %v), want (%v, %v)",
+			idx, nameValueMatch, staticTableLength(), false)
+	}
+}
+
+// Return n strings which all have the same h*31+c hash.  "Aa" and
+// "BB" have the same hash, and so do their concatenations of the same
+// length.
+func collidingStrings(prefix string, n int) []string {
+	strs := []string{prefix}
+
+	for len(strs) < n {
+		var next []string
+
+		for _, s := range strs {
+			next = append(next, s+"Aa", s+"BB")
+		}
+
+		strs = next
+	}
+
+	return strs[:n]
+}
+
+func TestHeaderSearchCollision(t *testing.T) {
+	const n = 1024
+
+	ht := newHeaderTable(1 << 20)
+	ht.buildIndex()
+
+	names := collidingStrings("x-", n)
+	values := collidingStrings("v", n)
+
+	for i := 0; i < n; i++ {
+		ht.PushFront(newHeaderTableEntry(NewHeader(names[i], "v", false)))
+		ht.PushFront(newHeaderTableEntry(NewHeader("x-name", values[i], false)))
+	}
+
+	for _, seqs := range ht.nameIndex {
+		// All entries of "x-name" share one bucket.
+		if len(seqs) > 2 && len(seqs) != n {
+			t.Errorf("len(seqs) = %v, want <= 2", len(seqs))
+		}
+	}
+
+	for _, seqs := range ht.nameValueIndex {
+		if len(seqs) > 2 {
+			t.Errorf("len(seqs) = %v, want <= 2", len(seqs))
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		idx, nameValueMatch := ht.Search(names[i], "v", false)
+		want := staticTableLength() + (n-1-i)*2 + 1
+
+		if idx != want || !nameValueMatch {
+			t.Errorf("ht.Search(%q, %q, false) = (%v, %v), want (%v, %v)",
+				names[i], "v", idx, nameValueMatch, want, true)
+		}
+
+		idx, nameValueMatch = ht.Search("x-name", values[i], false)
+		want = staticTableLength() + (n-1-i)*2
+
+		if idx != want || !nameValueMatch {
+			t.Errorf("ht.Search(%q, %q, false) = (%v, %v), want (%v, %v)",
+				"x-name", values[i], idx, nameValueMatch, want, true)
+		}
+	}
+}
+
+// Fill header table of size tableSize, and search for the oldest
+// entry, the worst case of linear search.
+// Search static table, and then dynamic table linearly, as Search did
+// before hash indexes were introduced.  This is the baseline of hash
+// indexes in benchmarks.  Like the old Search, the hashes of entries
+// are compared before their strings.
+func linearSearch(ht *headerTable, name string, value string, noNameValueMatch bool) (index int, nameValueMatch bool) {
+	index = -1
+
+	if idx, ok := staticNameIndex[name]; ok {
+		index = idx
+
+		if !noNameValueMatch {
+			if idx, ok := staticNameValueIndex[staticTableKey{name, value}]; ok {
+				return idx, true
+			}
+		}
+	}
+
+	if noNameValueMatch {
+		return
+	}
+
+	nameHash := maphash.String(ht.seed, name)
+	nvHash := nameValueHash(ht.seed, name, value)
+
+	for idx := 0; idx < ht.tablelen; idx++ {
+		entry := ht.dynget(idx)
+
+		if nameHash != entry.nameHash ||
+			!ctstreq(name, entry.header.Name) {
+			continue
+		}
+
+		if index == -1 {
+			index = idx + staticTableLength()
+		}
+
+		if nvHash == entry.nameValueHash &&
+			ctstreq(value, entry.header.Value) {
+			return idx + staticTableLength(), true
+		}
+	}
+
+	return
+}
+
+func benchmarkHeaderSearch(b *testing.B, tableSize uint, linear bool) {
+	ht := newHeaderTable(tableSize)
+	ht.buildIndex()
+
+	for i := 0; ht.tableSize+64 <= tableSize; i++ {
+		header := NewHeader(fmt.Sprintf("x-name%08d", i),
+			fmt.Sprintf("value%016d", i), false)
+
+		ht.PushFront(newHeaderTableEntry(header))
+	}
+
+	oldest := ht.dynget(ht.tablelen - 1).header
+
+	search := ht.Search
+	if linear {
+		search = func(name string, value string, noNameValueMatch bool) (int, bool) {
+			return linearSearch(ht, name, value, noNameValueMatch)
+		}
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, nameValueMatch := search(oldest.Name, oldest.Value, false); !nameValueMatch {
+			b.Fatalf("ht.Search(%q, %q, false) did not match",
+				oldest.Name, oldest.Value)
+		}
+
+		search(oldest.Name, "miss", false)
+		search("x-miss", "miss", false)
+	}
+}
+
+func BenchmarkHeaderSearch4K(b *testing.B) {
+	benchmarkHeaderSearch(b, 4096, false)
+}
+
+func BenchmarkHeaderSearch64K(b *testing.B) {
+	benchmarkHeaderSearch(b, 64<<10, false)
+}
+
+func BenchmarkHeaderSearch1M(b *testing.B) {
+	benchmarkHeaderSearch(b, 1<<20, false)
+}
+
+func BenchmarkHeaderSearchLinear4K(b *testing.B) {
+	benchmarkHeaderSearch(b, 4096, true)
+}
+
+func BenchmarkHeaderSearchLinear64K(b *testing.B) {
+	benchmarkHeaderSearch(b, 64<<10, true)
+}
+
+func BenchmarkHeaderSearchLinear1M(b *testing.B) {
+	benchmarkHeaderSearch(b, 1<<20, true)
+}
+
+// Insert header fields into a full table, so that each insertion
+// evicts an entry.  With index, this includes the cost of hashing
+// and updating indexes.
+func benchmarkHeaderPushFront(b *testing.B, index bool) {
+	ht := newHeaderTable(4096)
+
+	if index {
+		ht.buildIndex()
+	}
+
+	headers := make([]*Header, 1024)
+
+	for i := range headers {
+		headers[i] = NewHeader(fmt.Sprintf("x-name%08d", i),
+			fmt.Sprintf("value%016d", i), false)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ht.PushFront(newHeaderTableEntry(headers[i%len(headers)]))
+	}
+}
+
+func BenchmarkHeaderPushFront(b *testing.B) {
+	benchmarkHeaderPushFront(b, true)
+}
+
+func BenchmarkHeaderPushFrontNoIndex(b *testing.B) {
+	benchmarkHeaderPushFront(b, false)
+}
+
+// Search for the oldest entry in a table filled with header fields
+// whose names and values collide under h*31+c hash.
+func BenchmarkHeaderSearchCollision(b *testing.B) {
+	ht := newHeaderTable(1 << 20)
+	ht.buildIndex()
+
+	values := collidingStrings("v", 1<<14)
+
+	for _, value := range values {
+		ht.PushFront(newHeaderTableEntry(NewHeader("x-name", value, false)))
+	}
+
+	oldest := ht.dynget(ht.tablelen - 1).header
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, nameValueMatch := ht.Search(oldest.Name, oldest.Value, false); !nameValueMatch {
+			b.Fatalf("ht.Search(%q, %q, false) did not match",
+				oldest.Name, oldest.Value)
+		}
+	}
+}
@@ -39,8 +39,17 @@ type hpackTest struct {
 	Cases       []map[string]interface{}
 }
 
+var draft09 = flag.Bool("draft09", false,
+	"decode all input as draft-09 regardless of its draft field")
+
 func runTest(test *hpackTest) error {
-	decoder := hpack.NewDecoder()
+	spec := hpack.RFC7541
+
+	if *draft09 || test.Draft == 9 {
+		spec = hpack.Draft09
+	}
+
+	decoder := hpack.NewDecoderSpec(spec)
 
 	for seqno, testCase := range test.Cases {
 		wire := testCase["wire"].(string)
@@ -59,7 +68,7 @@ func runTest(test *hpackTest) error {
 				seqno)
 		}
 
-		headers := []map[string]string{}
+		headers := []interface{}{}
 
 		for cur := 0; cur < len(input); {
 			// Decode 1 byte at a time to check streaming
@@ -74,7 +83,7 @@ func runTest(test *hpackTest) error {
 
 			if header != nil {
 				headers = append(headers,
-					map[string]string{
+					map[string]interface{}{
 						header.Name: header.Value,
 					})
 			}
@@ -82,8 +91,8 @@ func runTest(test *hpackTest) error {
 			cur += nread
 		}
 
-		if reflect.DeepEqual(expectedHeaders, headers) {
-			fmt.Errorf("seqno %d: decoded = %v, want %v",
+		if !reflect.DeepEqual(expectedHeaders, headers) {
+			return fmt.Errorf("seqno %d: decoded = %v, want %v",
 				seqno, headers, expectedHeaders)
 		}
 	}
@@ -38,7 +38,6 @@ func encode(test map[string]interface{}) (map[string]interface{}, error) {
 	encoder := hpack.NewEncoder(hpack.DEFAULT_HEADER_TABLE_SIZE)
 	buffer := &bytes.Buffer{}
 	res := map[string]interface{}{
-		"draft": 9,
 		"description": "go-http2-hpack",
 	}
 	resCases := []map[string]interface{}{}
@@ -57,11 +56,13 @@ func encode(test map[string]interface{}) (map[string]interface{}, error) {
 			singleMap := hm.(map[string]interface{})
 			for k, v := range singleMap {
 				headers = append(headers,
-					&hpack.Header{k, v.(string), false})
+					hpack.NewHeader(k, v.(string), false))
 			}
 		}
 
-		encoder.Encode(buffer, headers)
+		if err := encoder.Encode(buffer, headers); err != nil {
+			return nil, err
+		}
 
 		resCase["wire"] = hex.EncodeToString(buffer.Bytes())
 		resCase["headers"] = testCase["headers"]
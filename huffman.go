@@ -25,65 +25,58 @@ package hpack
 
 import (
 	"bytes"
-	"fmt"
+	"encoding/binary"
+	"sync"
 )
 
-func huffmanEncodeSymbol(dst *bytes.Buffer, rembits int, sym *huffmanSymbol) int {
-	nbits := sym.nbits
-
-	for {
-		if rembits > nbits {
-			b := uint8(sym.code << uint(rembits-nbits))
-			dst.Bytes()[dst.Len()-1] |= b
-
-			rembits -= nbits
+// Huffman-encode str and write the output to dst.  str is treated
+// as arbitrary octet string, not as UTF-8 encoded text.
+func HuffmanEncode(dst *bytes.Buffer, str string) {
+	dst.Write(AppendHuffmanEncode(dst.AvailableBuffer(), str))
+}
 
-			break
-		}
+// Huffman-encode str, append the output to dst and return the
+// extended buffer.
+func AppendHuffmanEncode(dst []byte, str string) []byte {
+	// Codes are accumulated in the least significant nbits bits of
+	// acc, and flushed 32 bits at a time.  Since the longest code
+	// is 30 bits, nbits never exceeds 61.
+	var acc uint64
+	nbits := 0
 
-		b := uint8(sym.code >> uint(nbits-rembits))
-		dst.Bytes()[dst.Len()-1] |= b
+	for i := 0; i < len(str); i++ {
+		sym := &huffmanSymbolTable[str[i]]
 
-		nbits -= rembits
-		rembits = 8
+		acc = acc<<uint(sym.nbits) | uint64(sym.code)
+		nbits += sym.nbits
 
-		if nbits == 0 {
-			break
+		if nbits >= 32 {
+			nbits -= 32
+			dst = binary.BigEndian.AppendUint32(dst,
+				uint32(acc>>uint(nbits)))
 		}
-
-		dst.WriteByte(0)
 	}
 
-	return rembits
-}
-
-// Huffman-encode str and write the output to dst.
-func HuffmanEncode(dst *bytes.Buffer, str string) {
-	rembits := 8
-
-	for _, c := range str {
-		sym := &huffmanSymbolTable[c]
-
-		if rembits == 8 {
-			dst.WriteByte(0)
-		}
-
-		rembits = huffmanEncodeSymbol(dst, rembits, sym)
+	// Pad the last octet with the most significant bits of EOS,
+	// which are all 1.
+	if pad := (8 - nbits%8) % 8; pad > 0 {
+		acc = acc<<uint(pad) | (1<<uint(pad) - 1)
+		nbits += pad
 	}
 
-	if rembits < 8 {
-		sym := &huffmanSymbolTable[256]
-
-		b := uint8(sym.code >> uint(sym.nbits-rembits))
-		dst.Bytes()[dst.Len()-1] |= b
+	for nbits > 0 {
+		nbits -= 8
+		dst = append(dst, uint8(acc>>uint(nbits)))
 	}
+
+	return dst
 }
 
 // Return the length of bytes when str is huffman-encoded.
 func HuffmanEncodeLength(str string) int {
 	n := 0
-	for _, c := range str {
-		n += huffmanSymbolTable[c].nbits
+	for i := 0; i < len(str); i++ {
+		n += huffmanSymbolTable[str[i]].nbits
 	}
 	return (n + 7) / 8
 }
@@ -116,29 +109,225 @@ func (decoder *HuffmanDecoder) Reset() {
 // Decode src and write output to dst.  The final signals the end of
 // input.
 func (decoder *HuffmanDecoder) Decode(dst *bytes.Buffer, src []byte, final bool) error {
+	table := huffmanByteDecodeTable()
+	buf := dst.AvailableBuffer()
+	state := decoder.state
+	accept := decoder.accept
+
 	for _, c := range src {
-		x := c >> 4
-		for i := 0; i < 2; i++ {
-			t := &huffmanDecodeTable[decoder.state][x]
+		t := table[uint16(state)<<8|uint16(c)]
 
-			if (t.flags & huffmanDecodeFail) != 0 {
-				return fmt.Errorf("Huffman decode error")
-			}
+		switch t & huffmanByteSymbolMask {
+		case 2 << huffmanByteSymbolShift:
+			buf = append(buf, uint8(t>>8), uint8(t>>16))
+		case 1 << huffmanByteSymbolShift:
+			buf = append(buf, uint8(t>>8))
+		}
 
-			if (t.flags & huffmanDecodeSymbol) != 0 {
-				dst.WriteByte(t.symbol)
-			}
+		state = uint8(t)
+		accept = (t & huffmanByteAccept) != 0
 
-			decoder.state = t.state
-			decoder.accept = (t.flags & huffmanDecodeAccept) != 0
+		// The only code which fails is EOS.
+		if (t & huffmanByteFail) != 0 {
+			dst.Write(buf)
+			decoder.state = state
+			decoder.accept = accept
 
-			x = c & 0xf
+			return ErrHuffmanEOS
 		}
 	}
 
-	if final && !decoder.accept {
-		return fmt.Errorf("Huffman decode ended prematurely")
+	dst.Write(buf)
+	decoder.state = state
+	decoder.accept = accept
+
+	if final && !accept {
+		if huffmanDecodeStates()[state].eosPrefix {
+			return ErrHuffmanPaddingTooLong
+		}
+
+		return ErrHuffmanInvalidPadding
 	}
 
 	return nil
 }
+
+// An entry of huffmanByteDecodeTable packs the next state in bits
+// 0-7, up to 2 decoded symbols in bits 8-15 and 16-23, the number of
+// decoded symbols in bits 24-25, and flags.  If huffmanByteFail is
+// set, the state, the symbols and huffmanByteAccept are the ones
+// before EOS is decoded.
+const (
+	huffmanByteSymbolShift = 24
+	huffmanByteSymbolMask  = 0x3 << huffmanByteSymbolShift
+	huffmanByteAccept      = 1 << 26
+	huffmanByteFail        = 1 << 27
+)
+
+// Return decode table which consumes 8 bits at a time, indexed by
+// state<<8|octet.  This is built from huffmanDecodeTable by
+// composing 2 transitions of 4 bits.  It is built on the first call,
+// so that programs which never decode Huffman-encoded string do not
+// pay for it.
+var huffmanByteDecodeTable = sync.OnceValue(makeHuffmanByteDecodeTable)
+
+func makeHuffmanByteDecodeTable() *[1 << 16]uint32 {
+	table := new([1 << 16]uint32)
+	states := huffmanDecodeStates()
+
+	for state := range huffmanDecodeTable {
+		for c := 0; c < 256; c++ {
+			table[state<<8|c] = huffmanDecodeOctet(states,
+				uint8(state), uint8(c))
+		}
+	}
+
+	return table
+}
+
+// Return the entry of huffmanByteDecodeTable which decodes octet c
+// in state.  states is huffmanDecodeStates().
+func huffmanDecodeOctet(states []huffmanDecodeStateInfo, state, c uint8) uint32 {
+	var syms, flags uint32
+	nsym := uint32(0)
+
+	if states[state].eosPrefix && states[state].depth <= 7 {
+		flags = huffmanByteAccept
+	}
+
+	for _, x := range [2]uint8{c >> 4, c & 0xf} {
+		t := &huffmanDecodeTable[state][x]
+
+		if (t.flags & huffmanDecodeFail) != 0 {
+			flags |= huffmanByteFail
+			break
+		}
+
+		if (t.flags & huffmanDecodeSymbol) != 0 {
+			syms |= uint32(t.symbol) << (8 + 8*nsym)
+			nsym++
+		}
+
+		state = t.state
+		flags &^= huffmanByteAccept
+
+		if (t.flags & huffmanDecodeAccept) != 0 {
+			flags |= huffmanByteAccept
+		}
+	}
+
+	return uint32(state) | syms | nsym<<huffmanByteSymbolShift | flags
+}
+
+// A huffmanNode is a node of Huffman code tree.
+type huffmanNode struct {
+	// Child nodes for bit 0 and 1.  Both are nil if this is leaf.
+	children [2]*huffmanNode
+	// Symbol if this is leaf.
+	sym int
+}
+
+func (n *huffmanNode) leaf() bool {
+	return n.children[0] == nil
+}
+
+// Build Huffman code tree from codes indexed by symbol.
+func buildHuffmanTree(codes []huffmanSymbol) *huffmanNode {
+	root := &huffmanNode{}
+
+	for sym, code := range codes {
+		n := root
+
+		for i := code.nbits - 1; i >= 0; i-- {
+			b := (code.code >> uint(i)) & 1
+
+			if n.children[b] == nil {
+				n.children[b] = &huffmanNode{}
+			}
+
+			n = n.children[b]
+		}
+
+		n.sym = sym
+	}
+
+	return root
+}
+
+// Information about a state of huffmanDecodeTable.  Each state
+// corresponds to an internal node of Huffman code tree.
+type huffmanDecodeStateInfo struct {
+	// The node of Huffman code tree.
+	node *huffmanNode
+	// The number of bits from the root of the tree.
+	depth int
+	// true if all bits from the root are 1, that is, they are the
+	// most significant bits of EOS.
+	eosPrefix bool
+}
+
+// Return information about each state of huffmanDecodeTable, indexed
+// by state.  It is only needed to build huffmanByteDecodeTable and to
+// classify invalid padding, and it is built on the first call.
+var huffmanDecodeStates = sync.OnceValue(func() []huffmanDecodeStateInfo {
+	return makeHuffmanDecodeStates(huffmanSymbolTable)
+})
+
+// Walk Huffman code tree from the node of state info by 4 bits x, and
+// return the information of resulting node.  The returned sym is the
+// decoded symbol, or -1 if no symbol was decoded.
+func huffmanWalk(root *huffmanNode, info huffmanDecodeStateInfo, x uint8) (next huffmanDecodeStateInfo, sym int) {
+	sym = -1
+
+	for i := 3; i >= 0; i-- {
+		b := (x >> uint(i)) & 1
+
+		info.node = info.node.children[b]
+		info.depth++
+		info.eosPrefix = info.eosPrefix && b == 1
+
+		if info.node.leaf() {
+			sym = info.node.sym
+
+			if sym == 256 {
+				return info, sym
+			}
+
+			info = huffmanDecodeStateInfo{root, 0, true}
+		}
+	}
+
+	return info, sym
+}
+
+// Associate each state of huffmanDecodeTable with the node of
+// Huffman code tree built from codes, by following transitions from
+// the initial state.
+func makeHuffmanDecodeStates(codes []huffmanSymbol) []huffmanDecodeStateInfo {
+	root := buildHuffmanTree(codes)
+	infos := make([]huffmanDecodeStateInfo, len(huffmanDecodeTable))
+	infos[0] = huffmanDecodeStateInfo{root, 0, true}
+	queue := []uint8{0}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		for x := uint8(0); x < 16; x++ {
+			next, sym := huffmanWalk(root, infos[state], x)
+
+			if sym == 256 {
+				continue
+			}
+
+			t := &huffmanDecodeTable[state][x]
+
+			if infos[t.state].node == nil {
+				infos[t.state] = next
+				queue = append(queue, t.state)
+			}
+		}
+	}
+
+	return infos
+}
@@ -26,6 +26,9 @@ package hpack
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
+	"math/rand"
+	"reflect"
 	"testing"
 )
 
@@ -89,14 +92,442 @@ func TestHuffmanDecodeEndsPrematury(t *testing.T) {
 
 	err := decoder.Decode(output, buffer.Bytes(), true)
 
-	if err == nil {
-		t.Errorf("decoder.Decode(%v) must return error",
-			hex.EncodeToString(buffer.Bytes()))
+	if !errors.Is(err, ErrHuffmanInvalidPadding) {
+		t.Errorf("decoder.Decode(%v) returned %v, want %v",
+			hex.EncodeToString(buffer.Bytes()), err,
+			ErrHuffmanInvalidPadding)
 	}
+}
+
+func TestHuffmanDecodeStrict(t *testing.T) {
+	for _, test := range []struct {
+		input []byte
+		err   error
+	}{
+		// '0' + 3 bits padding
+		{[]byte{0x07}, nil},
+		// '0' '0' + 6 bits padding
+		{[]byte{0x00, 0x3f}, nil},
+		// EOS
+		{[]byte{0xff, 0xff, 0xff, 0xff}, ErrHuffmanEOS},
+		// 'a' + EOS + 'a'
+		{[]byte{0x1f, 0xff, 0xff, 0xff, 0xe3}, ErrHuffmanEOS},
+		// 8 bits padding
+		{[]byte{0xff}, ErrHuffmanPaddingTooLong},
+		// '0' + 11 bits padding
+		{[]byte{0x07, 0xff}, ErrHuffmanPaddingTooLong},
+		// '0' + padding '101'
+		{[]byte{0x05}, ErrHuffmanInvalidPadding},
+		// '0' + padding '000'
+		{[]byte{0x00}, ErrHuffmanInvalidPadding},
+	} {
+		// Feed input at once, and then 1 byte at a time.
+		for _, chunk := range []int{len(test.input), 1} {
+			decoder := NewHuffmanDecoder()
+			output := &bytes.Buffer{}
+
+			var err error
+
+			for i := 0; i < len(test.input) && err == nil; i += chunk {
+				end := i + chunk
+				if end > len(test.input) {
+					end = len(test.input)
+				}
+
+				err = decoder.Decode(output, test.input[i:end],
+					end == len(test.input))
+			}
+
+			if err != test.err {
+				t.Errorf("decoder.Decode(%v) returned %v, want %v",
+					hex.EncodeToString(test.input), err,
+					test.err)
+			}
+		}
+	}
+}
+
+// Check that huffmanSymbolTable and huffmanDecodeTable agree with the
+// Huffman code defined in RFC 7541.
+func TestHuffmanTablesRFC7541(t *testing.T) {
+	codes := make([]huffmanSymbol, len(rfc7541HuffmanCodes))
+
+	for sym, code := range rfc7541HuffmanCodes {
+		codes[sym] = huffmanSymbol{code.nbits, code.code}
+	}
+
+	if !reflect.DeepEqual(codes, huffmanSymbolTable) {
+		t.Errorf("huffmanSymbolTable does not match RFC 7541")
+	}
+
+	infos := makeHuffmanDecodeStates(codes)
+	root := infos[0].node
 
-	expected := "Huffman decode ended prematurely"
+	for state, info := range infos {
+		if info.node == nil {
+			t.Errorf("state %v is unreachable", state)
+			continue
+		}
 
-	if err.Error() != expected {
-		t.Errorf("error = %v, want %v", err.Error(), err)
+		for x := uint8(0); x < 16; x++ {
+			tr := huffmanDecodeTable[state][x]
+			next, sym := huffmanWalk(root, info, x)
+
+			if sym == 256 {
+				if tr.flags&huffmanDecodeFail == 0 {
+					t.Errorf("state %v, input %x: "+
+						"decoding EOS must fail", state, x)
+				}
+
+				continue
+			}
+
+			if tr.flags&huffmanDecodeFail != 0 {
+				t.Errorf("state %v, input %x: must not fail",
+					state, x)
+			}
+
+			if (tr.flags&huffmanDecodeSymbol != 0) != (sym != -1) ||
+				(sym != -1 && int(tr.symbol) != sym) {
+				t.Errorf("state %v, input %x: symbol = (%v, %v), want %v",
+					state, x,
+					tr.flags&huffmanDecodeSymbol != 0,
+					tr.symbol, sym)
+			}
+
+			if infos[tr.state] != next {
+				t.Errorf("state %v, input %x: next state %v "+
+					"does not match code tree",
+					state, x, tr.state)
+			}
+
+			accept := next.eosPrefix && next.depth <= 7
+
+			if (tr.flags&huffmanDecodeAccept != 0) != accept {
+				t.Errorf("state %v, input %x: accept = %v, want %v",
+					state, x, !accept, accept)
+			}
+		}
 	}
 }
+
+func TestHuffmanAllOctets(t *testing.T) {
+	all := make([]byte, 256)
+
+	for i := range all {
+		all[i] = byte(i)
+	}
+
+	inputs := []string{
+		string(all),
+		// Invalid UTF-8 sequences
+		"\xff\xfe\xfd",
+		"\xc3\x28",
+		"\xe2\x28\xa1",
+		"caf\xe9",
+	}
+
+	for i := 0; i < 256; i++ {
+		inputs = append(inputs, string([]byte{byte(i)}))
+	}
+
+	for _, input := range inputs {
+		buffer := &bytes.Buffer{}
+		HuffmanEncode(buffer, input)
+
+		if HuffmanEncodeLength(input) != buffer.Len() {
+			t.Errorf("HuffmanEncodeLength(%q) = %v, want %v",
+				input, HuffmanEncodeLength(input),
+				buffer.Len())
+		}
+
+		appended := AppendHuffmanEncode([]byte("x"), input)
+
+		if !bytes.Equal(appended[1:], buffer.Bytes()) {
+			t.Errorf("AppendHuffmanEncode(%q) = %x, want %x",
+				input, appended[1:], buffer.Bytes())
+		}
+
+		decoder := NewHuffmanDecoder()
+
+		output := &bytes.Buffer{}
+
+		err := decoder.Decode(output, buffer.Bytes(), true)
+
+		if err != nil {
+			t.Errorf("decoder.Decode(%v) returns error %v",
+				hex.EncodeToString(buffer.Bytes()), err)
+		}
+
+		if input != output.String() {
+			t.Errorf("decoder.Decode(%v) = %q, want %q",
+				hex.EncodeToString(buffer.Bytes()),
+				output.String(), input)
+		}
+	}
+}
+
+// Decode src 4 bits at a time using huffmanDecodeTable.  This is the
+// reference implementation of HuffmanDecoder.Decode.
+func nibbleHuffmanDecode(decoder *HuffmanDecoder, dst *bytes.Buffer, src []byte, final bool) error {
+	for _, c := range src {
+		x := c >> 4
+		for i := 0; i < 2; i++ {
+			t := &huffmanDecodeTable[decoder.state][x]
+
+			// The only code which fails is EOS.
+			if (t.flags & huffmanDecodeFail) != 0 {
+				return ErrHuffmanEOS
+			}
+
+			if (t.flags & huffmanDecodeSymbol) != 0 {
+				dst.WriteByte(t.symbol)
+			}
+
+			decoder.state = t.state
+			decoder.accept = (t.flags & huffmanDecodeAccept) != 0
+
+			x = c & 0xf
+		}
+	}
+
+	if final && !decoder.accept {
+		if huffmanDecodeStates()[decoder.state].eosPrefix {
+			return ErrHuffmanPaddingTooLong
+		}
+
+		return ErrHuffmanInvalidPadding
+	}
+
+	return nil
+}
+
+// Decode input split into chunks of size chunk with both decoder and
+// the reference, and report any difference.
+func checkHuffmanDecode(t *testing.T, input []byte, chunk int) {
+	decoder := NewHuffmanDecoder()
+	ref := NewHuffmanDecoder()
+	output := &bytes.Buffer{}
+	refOutput := &bytes.Buffer{}
+
+	var err, refErr error
+
+	for i := 0; i < len(input) && err == nil && refErr == nil; i += chunk {
+		end := min(i+chunk, len(input))
+
+		err = decoder.Decode(output, input[i:end], end == len(input))
+		refErr = nibbleHuffmanDecode(ref, refOutput, input[i:end],
+			end == len(input))
+	}
+
+	if err != refErr || !bytes.Equal(output.Bytes(), refOutput.Bytes()) ||
+		*decoder != *ref {
+		t.Errorf("decoder.Decode(%v) with chunk %v = (%q, %v, %v), want (%q, %v, %v)",
+			hex.EncodeToString(input), chunk, output.Bytes(), err,
+			*decoder, refOutput.Bytes(), refErr, *ref)
+	}
+}
+
+func TestHuffmanDecodeMatchesNibble(t *testing.T) {
+	for i := 0; i < 1<<16; i++ {
+		checkHuffmanDecode(t, []byte{byte(i >> 8), byte(i)}, 2)
+		checkHuffmanDecode(t, []byte{byte(i >> 8), byte(i)}, 1)
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 10000; i++ {
+		input := make([]byte, rnd.Intn(64))
+
+		if i%2 == 0 {
+			// Random octets mostly fail, so decode valid
+			// encodings too, with random last octet.
+			str := make([]byte, rnd.Intn(48))
+			rnd.Read(str)
+			input = AppendHuffmanEncode(nil, string(str))
+
+			if len(input) > 0 && i%4 == 0 {
+				input[len(input)-1] = byte(rnd.Intn(256))
+			}
+		} else {
+			rnd.Read(input)
+		}
+
+		checkHuffmanDecode(t, input, len(input)+1)
+		checkHuffmanDecode(t, input, 1+rnd.Intn(8))
+	}
+}
+
+func benchmarkHuffmanDecode(b *testing.B, decode func(*HuffmanDecoder, *bytes.Buffer, []byte, bool) error) {
+	input := AppendHuffmanEncode(nil,
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 "+
+			"(KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36; "+
+			"session=3f2a9c1e7b5d4068a1c2e3f4a5b6c7d8; "+
+			"_ga=GA1.2.1234567890.1700000000")
+	decoder := NewHuffmanDecoder()
+	output := &bytes.Buffer{}
+
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		decoder.Reset()
+		output.Reset()
+
+		if err := decode(decoder, output, input, true); err != nil {
+			b.Fatalf("decode(...) returns error %v", err)
+		}
+	}
+}
+
+func BenchmarkHuffmanDecode(b *testing.B) {
+	benchmarkHuffmanDecode(b, (*HuffmanDecoder).Decode)
+}
+
+func BenchmarkHuffmanDecodeNibble(b *testing.B) {
+	benchmarkHuffmanDecode(b, nibbleHuffmanDecode)
+}
+
+// Huffman-encode str 1 bit at a time.  This is the reference
+// implementation of AppendHuffmanEncode.
+func bitwiseHuffmanEncode(dst []byte, str string) []byte {
+	nbits := 0
+
+	putBit := func(b uint32) {
+		if nbits%8 == 0 {
+			dst = append(dst, 0)
+		}
+
+		dst[len(dst)-1] |= uint8(b << uint(7-nbits%8))
+		nbits++
+	}
+
+	for i := 0; i < len(str); i++ {
+		sym := &huffmanSymbolTable[str[i]]
+
+		for j := sym.nbits - 1; j >= 0; j-- {
+			putBit((sym.code >> uint(j)) & 1)
+		}
+	}
+
+	eos := &huffmanSymbolTable[256]
+
+	for j := eos.nbits - 1; nbits%8 != 0; j-- {
+		putBit((eos.code >> uint(j)) & 1)
+	}
+
+	return dst
+}
+
+func FuzzHuffmanEncode(f *testing.F) {
+	f.Add([]byte("Hello, World"))
+	f.Add([]byte{})
+	f.Add([]byte{0x0, 0x1, 0x2, 0x3})
+	f.Add([]byte("\xff\xfe\xfd\xfc\xfb\xfa"))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		actual := AppendHuffmanEncode([]byte("x"), string(input))
+		expected := bitwiseHuffmanEncode([]byte("x"), string(input))
+
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("AppendHuffmanEncode(%q) = %x, want %x",
+				input, actual[1:], expected[1:])
+		}
+	})
+}
+
+func benchmarkHuffmanEncode(b *testing.B, encode func([]byte, string) []byte) {
+	input := "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 " +
+		"(KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36; " +
+		"session=3f2a9c1e7b5d4068a1c2e3f4a5b6c7d8; " +
+		"_ga=GA1.2.1234567890.1700000000"
+
+	var buf []byte
+
+	b.SetBytes(int64(len(input)))
+
+	for i := 0; i < b.N; i++ {
+		buf = encode(buf[:0], input)
+	}
+}
+
+func BenchmarkHuffmanEncode(b *testing.B) {
+	benchmarkHuffmanEncode(b, AppendHuffmanEncode)
+}
+
+func BenchmarkHuffmanEncodeBitwise(b *testing.B) {
+	benchmarkHuffmanEncode(b, bitwiseHuffmanEncode)
+}
+
+// Huffman code table transcribed from RFC 7541 Appendix B, indexed by
+// symbol.  The last entry is EOS.
+var rfc7541HuffmanCodes = [257]struct {
+	code  uint32
+	nbits int
+}{
+	{0x1ff8, 13}, {0x7fffd8, 23}, {0xfffffe2, 28}, {0xfffffe3, 28},
+	{0xfffffe4, 28}, {0xfffffe5, 28}, {0xfffffe6, 28}, {0xfffffe7, 28},
+	{0xfffffe8, 28}, {0xffffea, 24}, {0x3ffffffc, 30}, {0xfffffe9, 28},
+	{0xfffffea, 28}, {0x3ffffffd, 30}, {0xfffffeb, 28}, {0xfffffec, 28},
+	{0xfffffed, 28}, {0xfffffee, 28}, {0xfffffef, 28}, {0xffffff0, 28},
+	{0xffffff1, 28}, {0xffffff2, 28}, {0x3ffffffe, 30}, {0xffffff3, 28},
+	{0xffffff4, 28}, {0xffffff5, 28}, {0xffffff6, 28}, {0xffffff7, 28},
+	{0xffffff8, 28}, {0xffffff9, 28}, {0xffffffa, 28}, {0xffffffb, 28},
+	{0x14, 6}, {0x3f8, 10}, {0x3f9, 10}, {0xffa, 12},
+	{0x1ff9, 13}, {0x15, 6}, {0xf8, 8}, {0x7fa, 11},
+	{0x3fa, 10}, {0x3fb, 10}, {0xf9, 8}, {0x7fb, 11},
+	{0xfa, 8}, {0x16, 6}, {0x17, 6}, {0x18, 6},
+	{0x0, 5}, {0x1, 5}, {0x2, 5}, {0x19, 6},
+	{0x1a, 6}, {0x1b, 6}, {0x1c, 6}, {0x1d, 6},
+	{0x1e, 6}, {0x1f, 6}, {0x5c, 7}, {0xfb, 8},
+	{0x7ffc, 15}, {0x20, 6}, {0xffb, 12}, {0x3fc, 10},
+	{0x1ffa, 13}, {0x21, 6}, {0x5d, 7}, {0x5e, 7},
+	{0x5f, 7}, {0x60, 7}, {0x61, 7}, {0x62, 7},
+	{0x63, 7}, {0x64, 7}, {0x65, 7}, {0x66, 7},
+	{0x67, 7}, {0x68, 7}, {0x69, 7}, {0x6a, 7},
+	{0x6b, 7}, {0x6c, 7}, {0x6d, 7}, {0x6e, 7},
+	{0x6f, 7}, {0x70, 7}, {0x71, 7}, {0x72, 7},
+	{0xfc, 8}, {0x73, 7}, {0xfd, 8}, {0x1ffb, 13},
+	{0x7fff0, 19}, {0x1ffc, 13}, {0x3ffc, 14}, {0x22, 6},
+	{0x7ffd, 15}, {0x3, 5}, {0x23, 6}, {0x4, 5},
+	{0x24, 6}, {0x5, 5}, {0x25, 6}, {0x26, 6},
+	{0x27, 6}, {0x6, 5}, {0x74, 7}, {0x75, 7},
+	{0x28, 6}, {0x29, 6}, {0x2a, 6}, {0x7, 5},
+	{0x2b, 6}, {0x76, 7}, {0x2c, 6}, {0x8, 5},
+	{0x9, 5}, {0x2d, 6}, {0x77, 7}, {0x78, 7},
+	{0x79, 7}, {0x7a, 7}, {0x7b, 7}, {0x7ffe, 15},
+	{0x7fc, 11}, {0x3ffd, 14}, {0x1ffd, 13}, {0xffffffc, 28},
+	{0xfffe6, 20}, {0x3fffd2, 22}, {0xfffe7, 20}, {0xfffe8, 20},
+	{0x3fffd3, 22}, {0x3fffd4, 22}, {0x3fffd5, 22}, {0x7fffd9, 23},
+	{0x3fffd6, 22}, {0x7fffda, 23}, {0x7fffdb, 23}, {0x7fffdc, 23},
+	{0x7fffdd, 23}, {0x7fffde, 23}, {0xffffeb, 24}, {0x7fffdf, 23},
+	{0xffffec, 24}, {0xffffed, 24}, {0x3fffd7, 22}, {0x7fffe0, 23},
+	{0xffffee, 24}, {0x7fffe1, 23}, {0x7fffe2, 23}, {0x7fffe3, 23},
+	{0x7fffe4, 23}, {0x1fffdc, 21}, {0x3fffd8, 22}, {0x7fffe5, 23},
+	{0x3fffd9, 22}, {0x7fffe6, 23}, {0x7fffe7, 23}, {0xffffef, 24},
+	{0x3fffda, 22}, {0x1fffdd, 21}, {0xfffe9, 20}, {0x3fffdb, 22},
+	{0x3fffdc, 22}, {0x7fffe8, 23}, {0x7fffe9, 23}, {0x1fffde, 21},
+	{0x7fffea, 23}, {0x3fffdd, 22}, {0x3fffde, 22}, {0xfffff0, 24},
+	{0x1fffdf, 21}, {0x3fffdf, 22}, {0x7fffeb, 23}, {0x7fffec, 23},
+	{0x1fffe0, 21}, {0x1fffe1, 21}, {0x3fffe0, 22}, {0x1fffe2, 21},
+	{0x7fffed, 23}, {0x3fffe1, 22}, {0x7fffee, 23}, {0x7fffef, 23},
+	{0xfffea, 20}, {0x3fffe2, 22}, {0x3fffe3, 22}, {0x3fffe4, 22},
+	{0x7ffff0, 23}, {0x3fffe5, 22}, {0x3fffe6, 22}, {0x7ffff1, 23},
+	{0x3ffffe0, 26}, {0x3ffffe1, 26}, {0xfffeb, 20}, {0x7fff1, 19},
+	{0x3fffe7, 22}, {0x7ffff2, 23}, {0x3fffe8, 22}, {0x1ffffec, 25},
+	{0x3ffffe2, 26}, {0x3ffffe3, 26}, {0x3ffffe4, 26}, {0x7ffffde, 27},
+	{0x7ffffdf, 27}, {0x3ffffe5, 26}, {0xfffff1, 24}, {0x1ffffed, 25},
+	{0x7fff2, 19}, {0x1fffe3, 21}, {0x3ffffe6, 26}, {0x7ffffe0, 27},
+	{0x7ffffe1, 27}, {0x3ffffe7, 26}, {0x7ffffe2, 27}, {0xfffff2, 24},
+	{0x1fffe4, 21}, {0x1fffe5, 21}, {0x3ffffe8, 26}, {0x3ffffe9, 26},
+	{0xffffffd, 28}, {0x7ffffe3, 27}, {0x7ffffe4, 27}, {0x7ffffe5, 27},
+	{0xfffec, 20}, {0xfffff3, 24}, {0xfffed, 20}, {0x1fffe6, 21},
+	{0x3fffe9, 22}, {0x1fffe7, 21}, {0x1fffe8, 21}, {0x7ffff3, 23},
+	{0x3fffea, 22}, {0x3fffeb, 22}, {0x1ffffee, 25}, {0x1ffffef, 25},
+	{0xfffff4, 24}, {0xfffff5, 24}, {0x3ffffea, 26}, {0x7ffff4, 23},
+	{0x3ffffeb, 26}, {0x7ffffe6, 27}, {0x3ffffec, 26}, {0x3ffffed, 26},
+	{0x7ffffe7, 27}, {0x7ffffe8, 27}, {0x7ffffe9, 27}, {0x7ffffea, 27},
+	{0x7ffffeb, 27}, {0xffffffe, 28}, {0x7ffffec, 27}, {0x7ffffed, 27},
+	{0x7ffffee, 27}, {0x7ffffef, 27}, {0x7fffff0, 27}, {0x3ffffee, 26},
+	{0x3fffffff, 30},
+}
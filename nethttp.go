@@ -0,0 +1,293 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FromHTTPHeader converts h to header list.  The names are
+// lowercased, and each value of multi-valued field becomes a
+// separate header field.  Connection-specific header fields, which
+// are not allowed in HTTP/2, are removed: the ones listed in RFC 9113
+// Section 8.2.2, the ones nominated by connection header field, and
+// te with value other than "trailers".  Host header field is also
+// removed since it is conveyed by :authority.  The header fields are
+// sorted by name, so that the output is deterministic.
+func FromHTTPHeader(h http.Header) []*Header {
+	nominated := make(map[string]bool)
+
+	for _, value := range h.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			nominated[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+
+	names := make([]string, 0, len(h))
+
+	for name := range h {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var headers []*Header
+
+	for _, name := range names {
+		lname := strings.ToLower(name)
+
+		if connectionHeaders[lname] || nominated[lname] ||
+			lname == "host" {
+			continue
+		}
+
+		for _, value := range h[name] {
+			if lname == "te" && value != "trailers" {
+				continue
+			}
+
+			headers = append(headers, NewHeader(lname, value, false))
+		}
+	}
+
+	return headers
+}
+
+// ToHTTPHeader converts the regular header fields in headers to
+// http.Header with canonical names.  Pseudo-header fields are
+// ignored.  Multiple cookie header fields are joined into one.
+func ToHTTPHeader(headers []Header) http.Header {
+	h := make(http.Header)
+
+	for _, header := range JoinCookies(headers) {
+		if strings.HasPrefix(header.Name, ":") {
+			continue
+		}
+
+		h.Add(header.Name, header.Value)
+	}
+
+	return h
+}
+
+// RequestHeaders returns header list of req, which starts with
+// pseudo-header fields, followed by the regular header fields
+// converted by FromHTTPHeader.  :authority is req.Host, or
+// req.URL.Host if it is empty.  If both are empty, :authority is
+// omitted.  :scheme is req.URL.Scheme, or
+// derived from req.TLS if it is empty.  CONNECT request has neither
+// :scheme nor :path.  If req.ContentLength is positive and req.Header
+// has no Content-Length, content-length header field is added.
+func RequestHeaders(req *http.Request) []*Header {
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	authority := req.Host
+	if authority == "" {
+		authority = req.URL.Host
+	}
+
+	headers := []*Header{
+		NewHeader(":method", method, false),
+	}
+
+	if method != http.MethodConnect {
+		scheme := req.URL.Scheme
+		if scheme == "" {
+			if req.TLS != nil {
+				scheme = "https"
+			} else {
+				scheme = "http"
+			}
+		}
+
+		headers = append(headers, NewHeader(":scheme", scheme, false))
+	}
+
+	if authority != "" {
+		headers = append(headers,
+			NewHeader(":authority", authority, false))
+	}
+
+	if method != http.MethodConnect {
+		headers = append(headers,
+			NewHeader(":path", req.URL.RequestURI(), false))
+	}
+
+	headers = append(headers, FromHTTPHeader(req.Header)...)
+
+	if req.ContentLength > 0 && req.Header.Get("Content-Length") == "" {
+		headers = append(headers, NewHeader("content-length",
+			strconv.FormatInt(req.ContentLength, 10), false))
+	}
+
+	return headers
+}
+
+// ResponseHeaders returns header list of response with status code
+// statusCode and header fields h converted by FromHTTPHeader.
+func ResponseHeaders(statusCode int, h http.Header) []*Header {
+	headers := []*Header{
+		NewHeader(":status", strconv.Itoa(statusCode), false),
+	}
+
+	return append(headers, FromHTTPHeader(h)...)
+}
+
+// NewRequest returns http.Request built from decoded request header
+// list headers.  Body is http.NoBody.  ContentLength is taken from
+// content-length header field, or -1 if it is absent.  headers is
+// validated by Validator of Request kind first.  If it violates
+// HTTP/2 semantic rules or a value is invalid, this function returns
+// *MalformedError.
+func NewRequest(headers []Header) (*http.Request, error) {
+	var method, scheme, authority, path string
+
+	ptrs := make([]*Header, len(headers))
+
+	for i := range headers {
+		ptrs[i] = &headers[i]
+	}
+
+	if err := NewValidator(Request).Validate(ptrs); err != nil {
+		return nil, err
+	}
+
+	for _, header := range headers {
+		switch header.Name {
+		case ":method":
+			method = header.Value
+		case ":scheme":
+			scheme = header.Value
+		case ":authority":
+			authority = header.Value
+		case ":path":
+			path = header.Value
+		}
+	}
+
+	h := ToHTTPHeader(headers)
+
+	if authority == "" {
+		authority = h.Get("Host")
+	}
+
+	var u *url.URL
+
+	if method == http.MethodConnect {
+		u = &url.URL{Host: authority}
+		path = authority
+	} else {
+		var err error
+
+		u, err = url.ParseRequestURI(path)
+
+		if err != nil {
+			return nil, &MalformedError{":path", err.Error()}
+		}
+
+		u.Scheme = scheme
+		u.Host = authority
+	}
+
+	contentLength, err := parseContentLength(h)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Request{
+		Method:        method,
+		URL:           u,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        h,
+		ContentLength: contentLength,
+		Body:          http.NoBody,
+		Host:          authority,
+		RequestURI:    path,
+	}, nil
+}
+
+// NewResponse returns http.Response built from decoded response
+// header list headers.  Body is http.NoBody.  ContentLength is
+// taken from content-length header field, or -1 if it is absent.  If
+// :status is missing or invalid, this function returns
+// *MalformedError.
+func NewResponse(headers []Header) (*http.Response, error) {
+	var status string
+
+	for _, header := range headers {
+		if header.Name == ":status" {
+			status = header.Value
+		}
+	}
+
+	if !validStatus(status) {
+		return nil, &MalformedError{":status", "missing or invalid"}
+	}
+
+	statusCode, _ := strconv.Atoi(status)
+
+	h := ToHTTPHeader(headers)
+
+	contentLength, err := parseContentLength(h)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        status + " " + http.StatusText(statusCode),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        h,
+		ContentLength: contentLength,
+		Body:          http.NoBody,
+	}, nil
+}
+
+// Return the value of Content-Length in h, or -1 if it is absent.
+func parseContentLength(h http.Header) (int64, error) {
+	value := h.Get("Content-Length")
+
+	if value == "" {
+		return -1, nil
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil || n < 0 {
+		return 0, &MalformedError{"content-length", "invalid value"}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,242 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestFromHTTPHeader(t *testing.T) {
+	h := http.Header{
+		"Accept":     {"text/html", "*/*"},
+		"Connection": {"keep-alive, X-Hop"},
+		"Keep-Alive": {"timeout=5"},
+		"X-Hop":      {"1"},
+		"Te":         {"trailers", "gzip"},
+		"Host":       {"example.org"},
+		"User-Agent": {"nghttp2"},
+	}
+
+	expected := []*Header{
+		NewHeader("accept", "text/html", false),
+		NewHeader("accept", "*/*", false),
+		NewHeader("te", "trailers", false),
+		NewHeader("user-agent", "nghttp2", false),
+	}
+
+	if headers := FromHTTPHeader(h); !reflect.DeepEqual(headers, expected) {
+		t.Errorf("FromHTTPHeader(...) = %v, want %v", headers, expected)
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	req, err := http.NewRequest("POST",
+		"https://example.org/search?q=hpack", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Add("Cookie", "a=b")
+	req.Header.Add("Cookie", "c=d")
+	req.ContentLength = 5
+
+	expected := []*Header{
+		NewHeader(":method", "POST", false),
+		NewHeader(":scheme", "https", false),
+		NewHeader(":authority", "example.org", false),
+		NewHeader(":path", "/search?q=hpack", false),
+		NewHeader("content-type", "text/plain", false),
+		NewHeader("cookie", "a=b", false),
+		NewHeader("cookie", "c=d", false),
+		NewHeader("content-length", "5", false),
+	}
+
+	headers := RequestHeaders(req)
+
+	if !reflect.DeepEqual(headers, expected) {
+		t.Fatalf("RequestHeaders(...) = %v, want %v", headers, expected)
+	}
+
+	encoded := &bytes.Buffer{}
+
+	NewEncoder(DEFAULT_HEADER_TABLE_SIZE).Encode(encoded, headers)
+
+	decoded, err := NewDecoder().DecodeFull(encoded.Bytes())
+
+	if err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	req2, err := NewRequest(decoded)
+
+	if err != nil {
+		t.Fatalf("NewRequest(...) returned error %v", err)
+	}
+
+	if req2.Method != "POST" || req2.URL.String() != "https://example.org/search?q=hpack" ||
+		req2.Host != "example.org" || req2.RequestURI != "/search?q=hpack" {
+		t.Errorf("NewRequest(...) = %v %v %v %v", req2.Method, req2.URL,
+			req2.Host, req2.RequestURI)
+	}
+
+	if req2.ContentLength != 5 {
+		t.Errorf("req2.ContentLength = %v, want %v", req2.ContentLength, 5)
+	}
+
+	if req2.Body != http.NoBody {
+		t.Errorf("req2.Body = %v, want %v", req2.Body, http.NoBody)
+	}
+
+	expectedHeader := http.Header{
+		"Content-Type":   {"text/plain"},
+		"Cookie":         {"a=b; c=d"},
+		"Content-Length": {"5"},
+	}
+
+	if !reflect.DeepEqual(req2.Header, expectedHeader) {
+		t.Errorf("req2.Header = %v, want %v", req2.Header, expectedHeader)
+	}
+}
+
+func TestConnectRequest(t *testing.T) {
+	req := &http.Request{
+		Method: "CONNECT",
+		Host:   "example.org:443",
+		URL:    &url.URL{Host: "example.org:443"},
+		Header: http.Header{},
+	}
+
+	expected := []*Header{
+		NewHeader(":method", "CONNECT", false),
+		NewHeader(":authority", "example.org:443", false),
+	}
+
+	if headers := RequestHeaders(req); !reflect.DeepEqual(headers, expected) {
+		t.Errorf("RequestHeaders(...) = %v, want %v", headers, expected)
+	}
+
+	req2, err := NewRequest([]Header{
+		{Name: ":method", Value: "CONNECT"},
+		{Name: ":authority", Value: "example.org:443"},
+	})
+
+	if err != nil {
+		t.Fatalf("NewRequest(...) returned error %v", err)
+	}
+
+	if req2.URL.Host != "example.org:443" || req2.ContentLength != -1 {
+		t.Errorf("NewRequest(...) = %v %v, want %v %v", req2.URL.Host,
+			req2.ContentLength, "example.org:443", -1)
+	}
+
+	_, err = NewRequest([]Header{{Name: ":method", Value: "GET"}})
+
+	var merr *MalformedError
+
+	if !errors.As(err, &merr) || merr.Name != ":path" {
+		t.Errorf("NewRequest(...) returned %v, want missing :path", err)
+	}
+
+	_, err = NewRequest([]Header{
+		{Name: ":method", Value: "GET"},
+		{Name: ":path", Value: "/"},
+	})
+
+	if !errors.As(err, &merr) || merr.Name != ":scheme" {
+		t.Errorf("NewRequest(...) returned %v, want missing :scheme", err)
+	}
+}
+
+func TestRequestHeadersNoAuthority(t *testing.T) {
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: "/"},
+		Header: http.Header{},
+	}
+
+	expected := []*Header{
+		NewHeader(":method", "GET", false),
+		NewHeader(":scheme", "http", false),
+		NewHeader(":path", "/", false),
+	}
+
+	headers := RequestHeaders(req)
+
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("RequestHeaders(...) = %v, want %v", headers, expected)
+	}
+
+	if err := NewValidator(Request).Validate(headers); err != nil {
+		t.Errorf("v.Validate(...) returned error %v", err)
+	}
+}
+
+func TestResponseRoundTrip(t *testing.T) {
+	headers := ResponseHeaders(404, http.Header{
+		"Content-Length": {"0"},
+		"Connection":     {"close"},
+	})
+
+	expected := []*Header{
+		NewHeader(":status", "404", false),
+		NewHeader("content-length", "0", false),
+	}
+
+	if !reflect.DeepEqual(headers, expected) {
+		t.Fatalf("ResponseHeaders(...) = %v, want %v", headers, expected)
+	}
+
+	decoded := make([]Header, len(headers))
+
+	for i, header := range headers {
+		decoded[i] = *header
+	}
+
+	resp, err := NewResponse(decoded)
+
+	if err != nil {
+		t.Fatalf("NewResponse(...) returned error %v", err)
+	}
+
+	if resp.StatusCode != 404 || resp.Status != "404 Not Found" ||
+		resp.ContentLength != 0 || resp.Body != http.NoBody {
+		t.Errorf("NewResponse(...) = %v %v %v %v", resp.StatusCode,
+			resp.Status, resp.ContentLength, resp.Body)
+	}
+
+	_, err = NewResponse(decoded[1:])
+
+	var merr *MalformedError
+
+	if !errors.As(err, &merr) || merr.Name != ":status" {
+		t.Errorf("NewResponse(...) returned %v, want missing :status", err)
+	}
+}
@@ -0,0 +1,273 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"encoding/binary"
+)
+
+// The serialized state starts with tag which identifies Encoder or
+// Decoder, and version of the format.  It is followed by the fields
+// of each type encoded as uvarint, and the header table entries from
+// the oldest to the newest.
+const (
+	encoderStateTag = 'E'
+	decoderStateTag = 'D'
+	stateVersion    = 1
+)
+
+// MarshalBinary serializes the compression state of enc: the header
+// table and pending table size changes.  The configuration given by
+// EncoderOptions and setters is not included.  To restore it, create
+// Encoder with the same configuration and call UnmarshalBinary.
+func (enc *Encoder) MarshalBinary() ([]byte, error) {
+	b := []byte{encoderStateTag, stateVersion}
+
+	b = binary.AppendUvarint(b, uint64(enc.encoderMaxTableSize))
+	b = binary.AppendUvarint(b, uint64(enc.settingsMinTableSize))
+	b = appendStateBool(b, enc.contextUpdate)
+	b = appendStateTable(b, enc.ht)
+
+	return b, nil
+}
+
+// UnmarshalBinary restores the compression state serialized by
+// MarshalBinary.  If data is invalid, this function returns
+// ErrInvalidState and enc is not modified.
+func (enc *Encoder) UnmarshalBinary(data []byte) error {
+	r := &stateReader{data: data}
+
+	r.readHeader(encoderStateTag)
+
+	encoderMaxTableSize := r.readUint()
+	settingsMinTableSize := r.readUint()
+	contextUpdate := r.readBool()
+	ht := r.readTable()
+
+	if err := r.end(); err != nil {
+		return err
+	}
+
+	if ht.maxTableSize > encoderMaxTableSize {
+		return ErrInvalidState
+	}
+
+	ht.buildIndex()
+
+	enc.ht = ht
+	enc.encoderMaxTableSize = encoderMaxTableSize
+	enc.settingsMinTableSize = settingsMinTableSize
+	enc.contextUpdate = contextUpdate
+
+	return nil
+}
+
+// MarshalBinary serializes the compression state of dec: the header
+// table and the table size limits set by ChangeTableSize.  It must be
+// called between header blocks, otherwise it returns ErrMidBlock.
+// If decoding failed before, it returns the error.  The
+// configuration such as Spec and validator is not included.  To
+// restore it, create Decoder with the same configuration and call
+// UnmarshalBinary.
+func (dec *Decoder) MarshalBinary() ([]byte, error) {
+	if dec.err != nil {
+		return nil, dec.err
+	}
+
+	if dec.state != stateOpcode || dec.fieldSeen ||
+		dec.tableSizeUpdates != 0 || dec.blockOffset != 0 {
+		return nil, ErrMidBlock
+	}
+
+	b := []byte{decoderStateTag, stateVersion}
+
+	b = binary.AppendUvarint(b, uint64(dec.settingsMaxTableSize))
+	b = binary.AppendUvarint(b, uint64(dec.settingsMinTableSize))
+	b = appendStateTable(b, dec.ht)
+
+	return b, nil
+}
+
+// UnmarshalBinary restores the compression state serialized by
+// MarshalBinary.  dec is ready to decode the next header block.  If
+// data is invalid, this function returns ErrInvalidState and dec is
+// not modified.
+func (dec *Decoder) UnmarshalBinary(data []byte) error {
+	r := &stateReader{data: data}
+
+	r.readHeader(decoderStateTag)
+
+	settingsMaxTableSize := r.readUint()
+	settingsMinTableSize := r.readUint()
+	ht := r.readTable()
+
+	if err := r.end(); err != nil {
+		return err
+	}
+
+	if ht.maxTableSize > settingsMaxTableSize {
+		return ErrInvalidState
+	}
+
+	dec.ht = ht
+	dec.settingsMaxTableSize = settingsMaxTableSize
+	dec.settingsMinTableSize = settingsMinTableSize
+	dec.err = nil
+	dec.state = stateOpcode
+	dec.opcode = opcodeNone
+	dec.entName = nil
+	dec.nvbuf.Reset()
+	dec.endBlock()
+
+	return nil
+}
+
+func appendStateBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 1)
+	}
+
+	return append(b, 0)
+}
+
+func appendStateString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendStateTable(b []byte, ht *headerTable) []byte {
+	b = binary.AppendUvarint(b, uint64(ht.maxTableSize))
+	b = binary.AppendUvarint(b, uint64(ht.tablelen))
+
+	for i := ht.tablelen - 1; i >= 0; i-- {
+		header := &ht.dynget(i).header
+
+		b = appendStateString(b, header.Name)
+		b = appendStateString(b, header.Value)
+	}
+
+	return b
+}
+
+// stateReader reads serialized state.  Once error occurs, the
+// following reads return zero value, and end returns
+// ErrInvalidState.
+type stateReader struct {
+	data []byte
+	err  bool
+}
+
+func (r *stateReader) readHeader(tag byte) {
+	if len(r.data) < 2 || r.data[0] != tag || r.data[1] != stateVersion {
+		r.err = true
+		return
+	}
+
+	r.data = r.data[2:]
+}
+
+func (r *stateReader) readUint() uint {
+	if r.err {
+		return 0
+	}
+
+	n, nread := binary.Uvarint(r.data)
+
+	if nread <= 0 || n > uint64(uint32Max) {
+		r.err = true
+		return 0
+	}
+
+	r.data = r.data[nread:]
+
+	return uint(n)
+}
+
+func (r *stateReader) readBool() bool {
+	if r.err || len(r.data) == 0 || r.data[0] > 1 {
+		r.err = true
+		return false
+	}
+
+	v := r.data[0] == 1
+	r.data = r.data[1:]
+
+	return v
+}
+
+func (r *stateReader) readString() string {
+	n := r.readUint()
+
+	if r.err || uint(len(r.data)) < n {
+		r.err = true
+		return ""
+	}
+
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+
+	return s
+}
+
+func (r *stateReader) readTable() *headerTable {
+	maxTableSize := r.readUint()
+	tablelen := r.readUint()
+
+	if r.err {
+		return nil
+	}
+
+	// maxTableSize is not trusted, and it may be much larger than
+	// the size of entries.  The ring buffer is sized from the
+	// entries, and it grows as they are inserted.
+	ht := newHeaderTable(0)
+	ht.maxTableSize = maxTableSize
+
+	for i := uint(0); i < tablelen; i++ {
+		header := &Header{Name: r.readString(), Value: r.readString()}
+
+		if r.err {
+			return nil
+		}
+
+		entry := newHeaderTableEntry(header)
+
+		if ht.tableSize+uint(entry.space()) > ht.maxTableSize {
+			r.err = true
+			return nil
+		}
+
+		ht.PushFront(entry)
+	}
+
+	return ht
+}
+
+func (r *stateReader) end() error {
+	if r.err || len(r.data) != 0 {
+		return ErrInvalidState
+	}
+
+	return nil
+}
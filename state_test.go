@@ -0,0 +1,278 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestEncoderMarshalBinary(t *testing.T) {
+	nva := []*Header{
+		NewHeader(":method", "GET", false),
+		NewHeader("alpha", "bravo", false),
+		NewHeader("charlie", "delta", false),
+	}
+
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+
+	enc.Encode(&bytes.Buffer{}, nva)
+
+	// Pending table size change must be restored too.
+	enc.ChangeTableSize(60)
+
+	data, err := enc.MarshalBinary()
+
+	if err != nil {
+		t.Fatalf("enc.MarshalBinary() returned error %v", err)
+	}
+
+	restored := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("restored.UnmarshalBinary(...) returned error %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		expected := &bytes.Buffer{}
+		actual := &bytes.Buffer{}
+
+		enc.Encode(expected, nva)
+		restored.Encode(actual, nva)
+
+		if !bytes.Equal(actual.Bytes(), expected.Bytes()) {
+			t.Errorf("restored.Encode(...) = %x, want %x",
+				actual.Bytes(), expected.Bytes())
+		}
+	}
+
+	// Invalid data does not modify encoder.
+	for _, data := range [][]byte{nil, data[:len(data)-1],
+		append(data, 0), []byte("D\x01")} {
+		if err := restored.UnmarshalBinary(data); err != ErrInvalidState {
+			t.Errorf("restored.UnmarshalBinary(%x) returned %v, want %v",
+				data, err, ErrInvalidState)
+		}
+	}
+
+	if restored.ht.tablelen != enc.ht.tablelen {
+		t.Errorf("restored.ht.tablelen = %v, want %v",
+			restored.ht.tablelen, enc.ht.tablelen)
+	}
+}
+
+func TestDecoderMarshalBinary(t *testing.T) {
+	nva := []*Header{
+		NewHeader(":method", "GET", false),
+		NewHeader("alpha", "bravo", false),
+		NewHeader("charlie", "delta", false),
+	}
+
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	dec := NewDecoder()
+
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, nva)
+
+	// Snapshot is not allowed in the middle of header block.
+	_, nread, err := dec.Decode(encoded.Bytes(), false)
+
+	if err != nil {
+		t.Fatalf("dec.Decode(...) returned error %v", err)
+	}
+
+	if _, err := dec.MarshalBinary(); err != ErrMidBlock {
+		t.Errorf("dec.MarshalBinary() returned %v, want %v",
+			err, ErrMidBlock)
+	}
+
+	if _, err := dec.DecodeFull(encoded.Bytes()[nread:]); err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	dec.ChangeTableSize(1024)
+	enc.ChangeTableSize(1024)
+
+	data, err := dec.MarshalBinary()
+
+	if err != nil {
+		t.Fatalf("dec.MarshalBinary() returned error %v", err)
+	}
+
+	restored := NewDecoder()
+
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatalf("restored.UnmarshalBinary(...) returned error %v", err)
+	}
+
+	// The restored decoder still requires dynamic table size
+	// update.
+	if restored.settingsMinTableSize != 1024 {
+		t.Errorf("restored.settingsMinTableSize = %v, want %v",
+			restored.settingsMinTableSize, 1024)
+	}
+
+	encoded.Reset()
+
+	enc.Encode(encoded, nva)
+
+	expected, err := dec.DecodeFull(encoded.Bytes())
+
+	if err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	actual, err := restored.DecodeFull(encoded.Bytes())
+
+	if err != nil {
+		t.Fatalf("restored.DecodeFull(...) returned error %v", err)
+	}
+
+	if len(actual) != len(expected) {
+		t.Fatalf("len(actual) = %v, want %v", len(actual), len(expected))
+	}
+
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("actual[%v] = %v, want %v",
+				i, actual[i], expected[i])
+		}
+	}
+
+	if err := restored.UnmarshalBinary([]byte("E\x01")); err != ErrInvalidState {
+		t.Errorf("restored.UnmarshalBinary(...) returned %v, want %v",
+			err, ErrInvalidState)
+	}
+}
+
+// Check that the state after encoding header field larger than the
+// header table can be restored.
+func TestMarshalBinaryOversizedEntry(t *testing.T) {
+	nva := []*Header{
+		// 1 + 1 + 32 = 34
+		NewHeader("a", "b", false),
+		// 10 + 4 + 32 = 46
+		NewHeader("user-agent", "curl", false),
+	}
+
+	for _, tableSize := range []uint{0, 40} {
+		enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+		dec := NewDecoder()
+
+		enc.ChangeTableSize(tableSize)
+		dec.ChangeTableSize(tableSize)
+
+		encoded := &bytes.Buffer{}
+
+		enc.Encode(encoded, nva)
+
+		if _, err := dec.DecodeFull(encoded.Bytes()); err != nil {
+			t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+		}
+
+		encData, err := enc.MarshalBinary()
+
+		if err != nil {
+			t.Fatalf("enc.MarshalBinary() returned error %v", err)
+		}
+
+		decData, err := dec.MarshalBinary()
+
+		if err != nil {
+			t.Fatalf("dec.MarshalBinary() returned error %v", err)
+		}
+
+		restoredEnc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+		restoredDec := NewDecoder()
+
+		if err := restoredEnc.UnmarshalBinary(encData); err != nil {
+			t.Fatalf("tableSize = %v: restoredEnc.UnmarshalBinary(...) returned error %v",
+				tableSize, err)
+		}
+
+		if err := restoredDec.UnmarshalBinary(decData); err != nil {
+			t.Fatalf("tableSize = %v: restoredDec.UnmarshalBinary(...) returned error %v",
+				tableSize, err)
+		}
+
+		expected := &bytes.Buffer{}
+		actual := &bytes.Buffer{}
+
+		enc.Encode(expected, nva)
+		restoredEnc.Encode(actual, nva)
+
+		if !bytes.Equal(actual.Bytes(), expected.Bytes()) {
+			t.Errorf("tableSize = %v: restoredEnc.Encode(...) = %x, want %x",
+				tableSize, actual.Bytes(), expected.Bytes())
+		}
+
+		if _, err := restoredDec.DecodeFull(actual.Bytes()); err != nil {
+			t.Errorf("tableSize = %v: restoredDec.DecodeFull(...) returned error %v",
+				tableSize, err)
+		}
+	}
+}
+
+// Check that the huge maximum header table size in serialized state
+// does not allocate the header table for it.
+func TestUnmarshalBinaryHugeTableSize(t *testing.T) {
+	var data []byte
+
+	data = append(data, encoderStateTag, stateVersion)
+	data = binary.AppendUvarint(data, uint64(uint32Max))
+	data = binary.AppendUvarint(data, uint64(uint32Max))
+	data = appendStateBool(data, false)
+	data = binary.AppendUvarint(data, uint64(uint32Max))
+	data = binary.AppendUvarint(data, 0)
+
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+
+	if err := enc.UnmarshalBinary(data); err != nil {
+		t.Fatalf("enc.UnmarshalBinary(...) returned error %v", err)
+	}
+
+	if len(enc.ht.table) != 1 {
+		t.Errorf("len(enc.ht.table) = %v, want %v", len(enc.ht.table), 1)
+	}
+
+	data = data[:0]
+
+	data = append(data, decoderStateTag, stateVersion)
+	data = binary.AppendUvarint(data, uint64(uint32Max))
+	data = binary.AppendUvarint(data, uint64(uint32Max))
+	data = binary.AppendUvarint(data, uint64(uint32Max))
+	data = binary.AppendUvarint(data, 0)
+
+	dec := NewDecoder()
+
+	if err := dec.UnmarshalBinary(data); err != nil {
+		t.Fatalf("dec.UnmarshalBinary(...) returned error %v", err)
+	}
+
+	if len(dec.ht.table) != 1 {
+		t.Errorf("len(dec.ht.table) = %v, want %v", len(dec.ht.table), 1)
+	}
+}
@@ -0,0 +1,214 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Code generated by gen_statictable.go from statictable.txt; DO NOT EDIT.
+
+package hpack
+
+// The static table defined in RFC 7541 Appendix A.
+var staticTable = []headerTableEntry{
+	makeEntry(":authority", ""),
+	makeEntry(":method", "GET"),
+	makeEntry(":method", "POST"),
+	makeEntry(":path", "/"),
+	makeEntry(":path", "/index.html"),
+	makeEntry(":scheme", "http"),
+	makeEntry(":scheme", "https"),
+	makeEntry(":status", "200"),
+	makeEntry(":status", "204"),
+	makeEntry(":status", "206"),
+	makeEntry(":status", "304"),
+	makeEntry(":status", "400"),
+	makeEntry(":status", "404"),
+	makeEntry(":status", "500"),
+	makeEntry("accept-charset", ""),
+	makeEntry("accept-encoding", "gzip, deflate"),
+	makeEntry("accept-language", ""),
+	makeEntry("accept-ranges", ""),
+	makeEntry("accept", ""),
+	makeEntry("access-control-allow-origin", ""),
+	makeEntry("age", ""),
+	makeEntry("allow", ""),
+	makeEntry("authorization", ""),
+	makeEntry("cache-control", ""),
+	makeEntry("content-disposition", ""),
+	makeEntry("content-encoding", ""),
+	makeEntry("content-language", ""),
+	makeEntry("content-length", ""),
+	makeEntry("content-location", ""),
+	makeEntry("content-range", ""),
+	makeEntry("content-type", ""),
+	makeEntry("cookie", ""),
+	makeEntry("date", ""),
+	makeEntry("etag", ""),
+	makeEntry("expect", ""),
+	makeEntry("expires", ""),
+	makeEntry("from", ""),
+	makeEntry("host", ""),
+	makeEntry("if-match", ""),
+	makeEntry("if-modified-since", ""),
+	makeEntry("if-none-match", ""),
+	makeEntry("if-range", ""),
+	makeEntry("if-unmodified-since", ""),
+	makeEntry("last-modified", ""),
+	makeEntry("link", ""),
+	makeEntry("location", ""),
+	makeEntry("max-forwards", ""),
+	makeEntry("proxy-authenticate", ""),
+	makeEntry("proxy-authorization", ""),
+	makeEntry("range", ""),
+	makeEntry("referer", ""),
+	makeEntry("refresh", ""),
+	makeEntry("retry-after", ""),
+	makeEntry("server", ""),
+	makeEntry("set-cookie", ""),
+	makeEntry("strict-transport-security", ""),
+	makeEntry("transfer-encoding", ""),
+	makeEntry("user-agent", ""),
+	makeEntry("vary", ""),
+	makeEntry("via", ""),
+	makeEntry("www-authenticate", ""),
+}
+
+// Map from header field name to the index of the first static table
+// entry which has that name.
+var staticNameIndex = map[string]int{
+	":authority":                  0,
+	":method":                     1,
+	":path":                       3,
+	":scheme":                     5,
+	":status":                     7,
+	"accept-charset":              14,
+	"accept-encoding":             15,
+	"accept-language":             16,
+	"accept-ranges":               17,
+	"accept":                      18,
+	"access-control-allow-origin": 19,
+	"age":                         20,
+	"allow":                       21,
+	"authorization":               22,
+	"cache-control":               23,
+	"content-disposition":         24,
+	"content-encoding":            25,
+	"content-language":            26,
+	"content-length":              27,
+	"content-location":            28,
+	"content-range":               29,
+	"content-type":                30,
+	"cookie":                      31,
+	"date":                        32,
+	"etag":                        33,
+	"expect":                      34,
+	"expires":                     35,
+	"from":                        36,
+	"host":                        37,
+	"if-match":                    38,
+	"if-modified-since":           39,
+	"if-none-match":               40,
+	"if-range":                    41,
+	"if-unmodified-since":         42,
+	"last-modified":               43,
+	"link":                        44,
+	"location":                    45,
+	"max-forwards":                46,
+	"proxy-authenticate":          47,
+	"proxy-authorization":         48,
+	"range":                       49,
+	"referer":                     50,
+	"refresh":                     51,
+	"retry-after":                 52,
+	"server":                      53,
+	"set-cookie":                  54,
+	"strict-transport-security":   55,
+	"transfer-encoding":           56,
+	"user-agent":                  57,
+	"vary":                        58,
+	"via":                         59,
+	"www-authenticate":            60,
+}
+
+// Map from header field name and value to the index of static table
+// entry.
+var staticNameValueIndex = map[staticTableKey]int{
+	{":authority", ""}:                   0,
+	{":method", "GET"}:                   1,
+	{":method", "POST"}:                  2,
+	{":path", "/"}:                       3,
+	{":path", "/index.html"}:             4,
+	{":scheme", "http"}:                  5,
+	{":scheme", "https"}:                 6,
+	{":status", "200"}:                   7,
+	{":status", "204"}:                   8,
+	{":status", "206"}:                   9,
+	{":status", "304"}:                   10,
+	{":status", "400"}:                   11,
+	{":status", "404"}:                   12,
+	{":status", "500"}:                   13,
+	{"accept-charset", ""}:               14,
+	{"accept-encoding", "gzip, deflate"}: 15,
+	{"accept-language", ""}:              16,
+	{"accept-ranges", ""}:                17,
+	{"accept", ""}:                       18,
+	{"access-control-allow-origin", ""}:  19,
+	{"age", ""}:                          20,
+	{"allow", ""}:                        21,
+	{"authorization", ""}:                22,
+	{"cache-control", ""}:                23,
+	{"content-disposition", ""}:          24,
+	{"content-encoding", ""}:             25,
+	{"content-language", ""}:             26,
+	{"content-length", ""}:               27,
+	{"content-location", ""}:             28,
+	{"content-range", ""}:                29,
+	{"content-type", ""}:                 30,
+	{"cookie", ""}:                       31,
+	{"date", ""}:                         32,
+	{"etag", ""}:                         33,
+	{"expect", ""}:                       34,
+	{"expires", ""}:                      35,
+	{"from", ""}:                         36,
+	{"host", ""}:                         37,
+	{"if-match", ""}:                     38,
+	{"if-modified-since", ""}:            39,
+	{"if-none-match", ""}:                40,
+	{"if-range", ""}:                     41,
+	{"if-unmodified-since", ""}:          42,
+	{"last-modified", ""}:                43,
+	{"link", ""}:                         44,
+	{"location", ""}:                     45,
+	{"max-forwards", ""}:                 46,
+	{"proxy-authenticate", ""}:           47,
+	{"proxy-authorization", ""}:          48,
+	{"range", ""}:                        49,
+	{"referer", ""}:                      50,
+	{"refresh", ""}:                      51,
+	{"retry-after", ""}:                  52,
+	{"server", ""}:                       53,
+	{"set-cookie", ""}:                   54,
+	{"strict-transport-security", ""}:    55,
+	{"transfer-encoding", ""}:            56,
+	{"user-agent", ""}:                   57,
+	{"vary", ""}:                         58,
+	{"via", ""}:                          59,
+	{"www-authenticate", ""}:             60,
+}
@@ -0,0 +1,112 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Check that staticTable and its lookup maps agree with
+// statictable.txt.  If this fails, run "go generate".
+func TestStaticTableGenerated(t *testing.T) {
+	f, err := os.Open("statictable.txt")
+	if err != nil {
+		t.Fatalf("os.Open(...) returns error %v", err)
+	}
+	defer f.Close()
+
+	var want []Header
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+
+		if index, err := strconv.Atoi(fields[0]); err != nil || index != len(want)+1 {
+			t.Fatalf("statictable.txt: index %v is out of order", fields[0])
+		}
+
+		header := Header{Name: fields[1]}
+
+		if len(fields) == 3 {
+			header.Value = fields[2]
+		}
+
+		want = append(want, header)
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("scanner.Err() = %v", err)
+	}
+
+	if len(staticTable) != len(want) {
+		t.Fatalf("len(staticTable) = %v, want %v", len(staticTable),
+			len(want))
+	}
+
+	names := 0
+
+	for i, header := range want {
+		if staticTable[i].header != header {
+			t.Errorf("staticTable[%v].header = %v, want %v", i,
+				staticTable[i].header, header)
+		}
+
+		if i == 0 || want[i-1].Name != header.Name {
+			names++
+
+			if idx, ok := staticNameIndex[header.Name]; !ok || idx != i {
+				t.Errorf("staticNameIndex[%q] = %v, %v, want %v, true",
+					header.Name, idx, ok, i)
+			}
+		}
+
+		key := staticTableKey{header.Name, header.Value}
+
+		if idx, ok := staticNameValueIndex[key]; !ok || idx != i {
+			t.Errorf("staticNameValueIndex[%v] = %v, %v, want %v, true",
+				key, idx, ok, i)
+		}
+	}
+
+	if len(staticNameIndex) != names {
+		t.Errorf("len(staticNameIndex) = %v, want %v",
+			len(staticNameIndex), names)
+	}
+
+	if len(staticNameValueIndex) != len(want) {
+		t.Errorf("len(staticNameValueIndex) = %v, want %v",
+			len(staticNameValueIndex), len(want))
+	}
+}
@@ -0,0 +1,127 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"iter"
+)
+
+// TableEntry is a read-only copy of header table entry.
+type TableEntry struct {
+	// HPACK index of this entry.  The static table entries start
+	// from 1, and the dynamic table entries follow them.
+	Index int
+	// Header field name
+	Name string
+	// Header field value
+	Value string
+	// The size of this entry: the sum of the length of name and
+	// value plus 32.
+	Space int
+}
+
+func makeTableEntry(index int, entry *headerTableEntry) TableEntry {
+	return TableEntry{index, entry.header.Name, entry.header.Value,
+		entry.space()}
+}
+
+// StaticTableLen returns the number of static table entries, which is
+// 61 in RFC 7541.  The dynamic table entries start from HPACK index
+// StaticTableLen()+1.
+func StaticTableLen() int {
+	return staticTableLength()
+}
+
+// StaticTable returns iterator over the static table entries in HPACK
+// index order.
+func StaticTable() iter.Seq[TableEntry] {
+	return func(yield func(TableEntry) bool) {
+		for i := range staticTable {
+			if !yield(makeTableEntry(i+1, &staticTable[i])) {
+				return
+			}
+		}
+	}
+}
+
+// Return iterator over the dynamic table entries in HPACK index
+// order, that is, from the newest to the oldest.
+func (ht *headerTable) entries() iter.Seq[TableEntry] {
+	return func(yield func(TableEntry) bool) {
+		for i := 0; i < ht.tablelen; i++ {
+			entry := makeTableEntry(staticTableLength()+i+1,
+				ht.dynget(i))
+
+			if !yield(entry) {
+				return
+			}
+		}
+	}
+}
+
+// Return the current size of dynamic table.
+func (enc *Encoder) DynamicTableSize() uint {
+	return enc.ht.tableSize
+}
+
+// Return the maximum size of dynamic table currently in effect.
+func (enc *Encoder) MaxDynamicTableSize() uint {
+	return enc.ht.maxTableSize
+}
+
+// Return the number of entries in dynamic table.
+func (enc *Encoder) DynamicTableLen() int {
+	return enc.ht.tablelen
+}
+
+// DynamicTable returns iterator over the dynamic table entries in
+// HPACK index order.  The encoder must not be used until iteration
+// finishes.
+func (enc *Encoder) DynamicTable() iter.Seq[TableEntry] {
+	return enc.ht.entries()
+}
+
+// Return the current size of dynamic table.
+func (dec *Decoder) DynamicTableSize() uint {
+	return dec.ht.tableSize
+}
+
+// Return the maximum size of dynamic table currently in effect.  It
+// is the size the encoder set by the last dynamic table size update,
+// or the smaller size set by ChangeTableSize since then.
+func (dec *Decoder) MaxDynamicTableSize() uint {
+	return dec.ht.maxTableSize
+}
+
+// Return the number of entries in dynamic table.
+func (dec *Decoder) DynamicTableLen() int {
+	return dec.ht.tablelen
+}
+
+// DynamicTable returns iterator over the dynamic table entries in
+// HPACK index order.  The decoder must not be used until iteration
+// finishes.
+func (dec *Decoder) DynamicTable() iter.Seq[TableEntry] {
+	return dec.ht.entries()
+}
@@ -0,0 +1,111 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestStaticTable(t *testing.T) {
+	entries := slices.Collect(StaticTable())
+
+	if len(entries) != 61 {
+		t.Fatalf("len(entries) = %v, want %v", len(entries), 61)
+	}
+
+	if StaticTableLen() != len(entries) {
+		t.Errorf("StaticTableLen() = %v, want %v",
+			StaticTableLen(), len(entries))
+	}
+
+	expected := TableEntry{2, ":method", "GET", 7 + 3 + 32}
+
+	if entries[1] != expected {
+		t.Errorf("entries[1] = %v, want %v", entries[1], expected)
+	}
+
+	expected = TableEntry{61, "www-authenticate", "", 16 + 32}
+
+	if entries[60] != expected {
+		t.Errorf("entries[60] = %v, want %v", entries[60], expected)
+	}
+}
+
+func TestDynamicTable(t *testing.T) {
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	dec := NewDecoder()
+
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, []*Header{
+		NewHeader("alpha", "bravo", false),
+		NewHeader("charlie", "delta", false),
+	})
+
+	if _, err := dec.DecodeFull(encoded.Bytes()); err != nil {
+		t.Fatalf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	expected := []TableEntry{
+		{62, "charlie", "delta", 7 + 5 + 32},
+		{63, "alpha", "bravo", 5 + 5 + 32},
+	}
+
+	if entries := slices.Collect(enc.DynamicTable()); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("enc.DynamicTable() = %v, want %v", entries, expected)
+	}
+
+	if entries := slices.Collect(dec.DynamicTable()); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("dec.DynamicTable() = %v, want %v", entries, expected)
+	}
+
+	if enc.DynamicTableSize() != 86 || dec.DynamicTableSize() != 86 {
+		t.Errorf("DynamicTableSize() = (%v, %v), want (%v, %v)",
+			enc.DynamicTableSize(), dec.DynamicTableSize(), 86, 86)
+	}
+
+	if enc.DynamicTableLen() != 2 || dec.DynamicTableLen() != 2 {
+		t.Errorf("DynamicTableLen() = (%v, %v), want (%v, %v)",
+			enc.DynamicTableLen(), dec.DynamicTableLen(), 2, 2)
+	}
+
+	if enc.MaxDynamicTableSize() != DEFAULT_HEADER_TABLE_SIZE ||
+		dec.MaxDynamicTableSize() != DEFAULT_HEADER_TABLE_SIZE {
+		t.Errorf("MaxDynamicTableSize() = (%v, %v), want (%v, %v)",
+			enc.MaxDynamicTableSize(), dec.MaxDynamicTableSize(),
+			DEFAULT_HEADER_TABLE_SIZE, DEFAULT_HEADER_TABLE_SIZE)
+	}
+
+	// Stop iteration early.
+	for entry := range dec.DynamicTable() {
+		if entry.Index != 62 {
+			t.Errorf("entry.Index = %v, want %v", entry.Index, 62)
+		}
+
+		break
+	}
+}
@@ -0,0 +1,371 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"fmt"
+)
+
+// MessageKind is the kind of HTTP message a header list belongs to.
+type MessageKind int
+
+const (
+	// Request header fields.
+	Request MessageKind = iota
+	// Response header fields.
+	Response
+	// Trailer fields of request or response.
+	Trailer
+)
+
+// A MalformedError is returned when a header list violates HTTP/2
+// semantic rules.  The message containing it is malformed, and the
+// stream should be reset with PROTOCOL_ERROR.  Unlike DecodingError,
+// the compression context is intact.
+type MalformedError struct {
+	// The name of offending header field.
+	Name string
+	// The reason why header field is malformed.
+	Reason string
+}
+
+func (e *MalformedError) Error() string {
+	return fmt.Sprintf("hpack: malformed header field %q: %v",
+		e.Name, e.Reason)
+}
+
+// Code returns HTTP/2 error code, which is always PROTOCOL_ERROR.
+func (e *MalformedError) Code() ErrCode {
+	return ErrCodeProtocol
+}
+
+const (
+	pseudoAuthority = 1 << iota
+	pseudoMethod
+	pseudoPath
+	pseudoScheme
+	pseudoProtocol
+	pseudoStatus
+)
+
+var pseudoHeaders = map[string]int{
+	":authority": pseudoAuthority,
+	":method":    pseudoMethod,
+	":path":      pseudoPath,
+	":scheme":    pseudoScheme,
+	":protocol":  pseudoProtocol,
+	":status":    pseudoStatus,
+}
+
+const (
+	requestPseudoHeaders = pseudoAuthority | pseudoMethod | pseudoPath |
+		pseudoScheme | pseudoProtocol
+	responsePseudoHeaders = pseudoStatus
+)
+
+// Connection-specific header fields which must not appear in HTTP/2.
+var connectionHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// A Validator checks header list against HTTP/2 semantic rules defined
+// in RFC 9113 section 8: field name and value syntax, pseudo-header
+// fields and connection-specific header fields.  Field and End are
+// stateful and check one header list at a time, so that each Decoder
+// needs its own Validator.  Validate does not use the state, and a
+// Validator set to Encoder can be shared.
+type Validator struct {
+	// The kind of message validated.  This can be changed between
+	// header lists, for example, to validate trailer fields.
+	Kind MessageKind
+	// Bitmask of pseudo-header fields seen so far.
+	pseudo int
+	// true if regular header field has been seen.
+	regularSeen bool
+	// The value of :method.
+	method string
+}
+
+// NewValidator returns new Validator which validates the given kind of
+// messages.
+func NewValidator(kind MessageKind) *Validator {
+	return &Validator{Kind: kind}
+}
+
+// Reset validator state so that it can validate new header list.
+func (v *Validator) Reset() {
+	v.pseudo = 0
+	v.regularSeen = false
+	v.method = ""
+}
+
+// Field validates header which is the next header field of the
+// current header list.
+func (v *Validator) Field(header *Header) error {
+	name := header.Name
+
+	if len(name) > 0 && name[0] == ':' {
+		return v.pseudoField(header)
+	}
+
+	if err := checkFieldName(name); err != nil {
+		return err
+	}
+
+	if err := checkFieldValue(header); err != nil {
+		return err
+	}
+
+	if connectionHeaders[name] {
+		return &MalformedError{name, "connection-specific header field"}
+	}
+
+	if name == "te" && header.Value != "trailers" {
+		return &MalformedError{name,
+			"value other than \"trailers\" is not allowed"}
+	}
+
+	v.regularSeen = true
+
+	return nil
+}
+
+func (v *Validator) pseudoField(header *Header) error {
+	name := header.Name
+	bit, ok := pseudoHeaders[name]
+
+	switch {
+	case !ok:
+		return &MalformedError{name, "unknown pseudo-header field"}
+	case v.Kind == Trailer:
+		return &MalformedError{name,
+			"pseudo-header field in trailer fields"}
+	case v.Kind == Request && bit&requestPseudoHeaders == 0,
+		v.Kind == Response && bit&responsePseudoHeaders == 0:
+		return &MalformedError{name,
+			"pseudo-header field not allowed in this message"}
+	case v.regularSeen:
+		return &MalformedError{name,
+			"pseudo-header field after regular header field"}
+	case v.pseudo&bit != 0:
+		return &MalformedError{name, "duplicate pseudo-header field"}
+	}
+
+	if err := checkFieldValue(header); err != nil {
+		return err
+	}
+
+	switch bit {
+	case pseudoMethod:
+		if err := checkToken(name, header.Value); err != nil {
+			return err
+		}
+
+		v.method = header.Value
+	case pseudoAuthority, pseudoPath:
+		if header.Value == "" {
+			return &MalformedError{name, "empty value"}
+		}
+	case pseudoStatus:
+		if !validStatus(header.Value) {
+			return &MalformedError{name, "not a 3-digit status code"}
+		}
+	}
+
+	v.pseudo |= bit
+
+	return nil
+}
+
+// End checks that the current header list contains all mandatory
+// pseudo-header fields.  Call this after the last header field of
+// header list was passed to Field().
+func (v *Validator) End() error {
+	switch v.Kind {
+	case Request:
+		if v.pseudo&pseudoMethod == 0 {
+			return &MalformedError{":method", "missing"}
+		}
+
+		if v.method == "CONNECT" {
+			if v.pseudo&pseudoProtocol != 0 {
+				// Extended CONNECT (RFC 8441) requires all
+				// of them.
+				return v.requirePseudo(pseudoScheme |
+					pseudoPath | pseudoAuthority)
+			}
+
+			if v.pseudo&pseudoAuthority == 0 {
+				return &MalformedError{":authority", "missing"}
+			}
+
+			for _, name := range []string{":scheme", ":path"} {
+				if v.pseudo&pseudoHeaders[name] != 0 {
+					return &MalformedError{name,
+						"not allowed in CONNECT request"}
+				}
+			}
+
+			return nil
+		}
+
+		if v.pseudo&pseudoProtocol != 0 {
+			return &MalformedError{":protocol",
+				"not allowed in non-CONNECT request"}
+		}
+
+		return v.requirePseudo(pseudoScheme | pseudoPath)
+	case Response:
+		return v.requirePseudo(pseudoStatus)
+	}
+
+	return nil
+}
+
+func (v *Validator) requirePseudo(mask int) error {
+	for _, name := range []string{":authority", ":method", ":path",
+		":scheme", ":protocol", ":status"} {
+		bit := pseudoHeaders[name]
+
+		if mask&bit != 0 && v.pseudo&bit == 0 {
+			return &MalformedError{name, "missing"}
+		}
+	}
+
+	return nil
+}
+
+// Validate headers as one complete header list.  This does not use or
+// change the validator state, so that it does not interfere with the
+// header list being checked by Field.
+func (v *Validator) Validate(headers []*Header) error {
+	local := NewValidator(v.Kind)
+
+	for _, header := range headers {
+		if err := local.Field(header); err != nil {
+			return err
+		}
+	}
+
+	return local.End()
+}
+
+// tchar defined in RFC 9110 section 5.6.2, excluding upper case
+// letters which are not allowed in HTTP/2 field name.
+func validNameChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+		return true
+	}
+
+	switch c {
+	case '!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_',
+		'`', '|', '~':
+		return true
+	}
+
+	return false
+}
+
+func checkFieldName(name string) error {
+	if name == "" {
+		return &MalformedError{name, "empty field name"}
+	}
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+
+		if 'A' <= c && c <= 'Z' {
+			return &MalformedError{name,
+				"upper case letter in field name"}
+		}
+
+		if !validNameChar(c) {
+			return &MalformedError{name, fmt.Sprintf(
+				"invalid character 0x%02x in field name", c)}
+		}
+	}
+
+	return nil
+}
+
+// Check that value is token, using the same character set as field
+// name except that upper case letters are allowed.
+func checkToken(name, value string) error {
+	if value == "" {
+		return &MalformedError{name, "empty value"}
+	}
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		if !validNameChar(c) && !('A' <= c && c <= 'Z') {
+			return &MalformedError{name, fmt.Sprintf(
+				"invalid character 0x%02x in value", c)}
+		}
+	}
+
+	return nil
+}
+
+func checkFieldValue(header *Header) error {
+	value := header.Value
+
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case 0, '\r', '\n':
+			return &MalformedError{header.Name, fmt.Sprintf(
+				"invalid character 0x%02x in value", value[i])}
+		}
+	}
+
+	if len(value) > 0 {
+		switch {
+		case value[0] == ' ' || value[0] == '\t',
+			value[len(value)-1] == ' ' || value[len(value)-1] == '\t':
+			return &MalformedError{header.Name,
+				"leading or trailing whitespace in value"}
+		}
+	}
+
+	return nil
+}
+
+func validStatus(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
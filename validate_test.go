@@ -0,0 +1,386 @@
+// go-http2-hpack - HTTP/2 HPACK implementation in golang
+//
+// Copyright (c) 2014 Tatsuhiro Tsujikawa
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package hpack
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestValidatorValidate(t *testing.T) {
+	request := []*Header{
+		&Header{":method", "GET", false},
+		&Header{":scheme", "https", false},
+		&Header{":authority", "example.org", false},
+		&Header{":path", "/", false},
+		&Header{"user-agent", "nghttp2", false},
+		&Header{"te", "trailers", false},
+	}
+
+	for _, test := range []struct {
+		kind    MessageKind
+		headers []*Header
+		// The name of offending header field, or "" if headers
+		// are valid.
+		name string
+	}{
+		{Request, request, ""},
+		{Request, []*Header{
+			&Header{":method", "CONNECT", false},
+			&Header{":authority", "example.org:443", false},
+		}, ""},
+		{Request, []*Header{
+			&Header{":method", "CONNECT", false},
+			&Header{":protocol", "websocket", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/chat", false},
+			&Header{":authority", "example.org", false},
+		}, ""},
+		{Response, []*Header{
+			&Header{":status", "200", false},
+			&Header{"content-type", "text/html", false},
+		}, ""},
+		{Trailer, []*Header{
+			&Header{"grpc-status", "0", false},
+		}, ""},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+			&Header{"User-Agent", "nghttp2", false},
+		}, "User-Agent"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+			&Header{"user agent", "nghttp2", false},
+		}, "user agent"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+			&Header{"x-injected", "a\r\nb: c", false},
+		}, "x-injected"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+			&Header{"x-nul", "a\x00b", false},
+		}, "x-nul"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+			&Header{"x-space", " a", false},
+		}, "x-space"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{"user-agent", "nghttp2", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+		}, ":scheme"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":method", "POST", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+		}, ":method"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+			&Header{":status", "200", false},
+		}, ":status"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+			&Header{":foo", "bar", false},
+		}, ":foo"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+		}, ":path"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+			NewHeader(":authority", "", false),
+			&Header{":path", "/", false},
+		}, ":authority"},
+		{Request, []*Header{
+			&Header{":method", "CONNECT", false},
+			&Header{":authority", "example.org:443", false},
+			&Header{":path", "/", false},
+		}, ":path"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+			&Header{"connection", "close", false},
+		}, "connection"},
+		{Request, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":scheme", "https", false},
+			&Header{":path", "/", false},
+			&Header{"te", "gzip", false},
+		}, "te"},
+		{Response, []*Header{
+			&Header{":status", "20", false},
+		}, ":status"},
+		{Response, []*Header{
+			&Header{":method", "GET", false},
+			&Header{":status", "200", false},
+		}, ":method"},
+		{Response, []*Header{
+			&Header{"content-type", "text/html", false},
+		}, ":status"},
+		{Trailer, []*Header{
+			&Header{":status", "200", false},
+		}, ":status"},
+	} {
+		v := NewValidator(test.kind)
+
+		err := v.Validate(test.headers)
+
+		if test.name == "" {
+			if err != nil {
+				t.Errorf("v.Validate(%v) returned error %v",
+					test.headers, err)
+			}
+
+			continue
+		}
+
+		var merr *MalformedError
+
+		if !errors.As(err, &merr) {
+			t.Errorf("v.Validate(%v) returned %v, want *MalformedError",
+				test.headers, err)
+			continue
+		}
+
+		if merr.Name != test.name {
+			t.Errorf("merr.Name = %q, want %q", merr.Name, test.name)
+		}
+
+		if merr.Code() != ErrCodeProtocol {
+			t.Errorf("merr.Code() = %v, want %v",
+				merr.Code(), ErrCodeProtocol)
+		}
+	}
+}
+
+func TestDecoderValidator(t *testing.T) {
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, []*Header{
+		&Header{":status", "200", false},
+		&Header{"Content-Type", "text/html", false},
+		&Header{"server", "nghttp2", false},
+	})
+
+	blocklen := encoded.Len()
+
+	enc.Encode(encoded, []*Header{
+		&Header{"server", "nghttp2", false},
+	})
+
+	dec := NewDecoder()
+	dec.SetValidator(NewValidator(Response))
+
+	var errs []error
+	var headers []*Header
+
+	for cur := 0; cur < encoded.Len(); {
+		end := blocklen
+		if cur >= blocklen {
+			end = encoded.Len()
+		}
+
+		header, nread, err := dec.Decode(encoded.Bytes()[cur:end], true)
+
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		if header != nil {
+			headers = append(headers, header)
+		}
+
+		cur += nread
+	}
+
+	// The first block has malformed Content-Type.  The second
+	// block lacks :status.
+	if len(errs) != 2 {
+		t.Fatalf("len(errs) = %v, want %v", len(errs), 2)
+	}
+
+	var merr *MalformedError
+
+	if !errors.As(errs[0], &merr) || merr.Name != "Content-Type" {
+		t.Errorf("errs[0] = %v, want malformed Content-Type", errs[0])
+	}
+
+	if !errors.As(errs[1], &merr) || merr.Name != ":status" {
+		t.Errorf("errs[1] = %v, want missing :status", errs[1])
+	}
+
+	// Decoding continued after malformed header field, and the
+	// header table is in sync.
+	if len(headers) != 2 || headers[1].Name != "server" {
+		t.Errorf("headers = %v, want [:status server]", headers)
+	}
+}
+
+func TestDecoderDecodeFullValidator(t *testing.T) {
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, []*Header{
+		&Header{":status", "200", false},
+		&Header{"Content-Type", "text/html", false},
+		&Header{"server", "nghttp2", false},
+	})
+
+	dec := NewDecoder()
+	dec.SetValidator(NewValidator(Response))
+
+	headers, err := dec.DecodeFull(encoded.Bytes())
+
+	var merr *MalformedError
+
+	if !errors.As(err, &merr) || merr.Name != "Content-Type" {
+		t.Errorf("dec.DecodeFull(...) returned %v, want malformed Content-Type", err)
+	}
+
+	if len(headers) != 1 || headers[0].Name != ":status" {
+		t.Errorf("headers = %v, want [:status]", headers)
+	}
+
+	// The whole block was decoded, and the next one can be decoded.
+	encoded.Reset()
+
+	enc.Encode(encoded, []*Header{
+		&Header{":status", "200", false},
+		&Header{"server", "nghttp2", false},
+	})
+
+	headers, err = dec.DecodeFull(encoded.Bytes())
+
+	if err != nil {
+		t.Errorf("dec.DecodeFull(...) returned error %v", err)
+	}
+
+	if len(headers) != 2 || headers[1].Name != "server" {
+		t.Errorf("headers = %v, want [:status server]", headers)
+	}
+}
+
+func TestEncoderValidator(t *testing.T) {
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	enc.SetValidator(NewValidator(Request))
+
+	encoded := &bytes.Buffer{}
+
+	err := enc.Encode(encoded, []*Header{
+		&Header{":method", "GET", false},
+		&Header{":scheme", "https", false},
+		&Header{":path", "/", false},
+		&Header{"x-foo", "bar\n", false},
+	})
+
+	var merr *MalformedError
+
+	if !errors.As(err, &merr) || merr.Name != "x-foo" {
+		t.Errorf("enc.Encode(...) returned %v, want malformed x-foo",
+			err)
+	}
+
+	if encoded.Len() != 0 {
+		t.Errorf("encoded.Len() = %v, want %v", encoded.Len(), 0)
+	}
+}
+
+// Check that Validate does not interfere with the header list being
+// checked by Field, so that a Validator can be shared by Encoder and
+// Decoder.
+func TestValidatorValidateKeepsState(t *testing.T) {
+	v := NewValidator(Request)
+
+	if err := v.Field(NewHeader(":method", "GET", false)); err != nil {
+		t.Fatalf("v.Field(...) returned error %v", err)
+	}
+
+	err := v.Validate([]*Header{
+		&Header{":method", "GET", false},
+		&Header{":scheme", "https", false},
+		&Header{":path", "/", false},
+	})
+
+	if err != nil {
+		t.Errorf("v.Validate(...) returned error %v", err)
+	}
+
+	// :method was already seen.
+	var merr *MalformedError
+
+	err = v.Field(NewHeader(":method", "GET", false))
+
+	if !errors.As(err, &merr) || merr.Name != ":method" {
+		t.Errorf("v.Field(...) returned %v, want duplicate :method", err)
+	}
+}
+
+// Check that Write reports that all input was processed when the
+// header list is malformed, since the header table was updated.
+func TestDecoderWriteValidator(t *testing.T) {
+	enc := NewEncoder(DEFAULT_HEADER_TABLE_SIZE)
+	encoded := &bytes.Buffer{}
+
+	enc.Encode(encoded, []*Header{
+		NewHeader("x-foo", "bar", false),
+		// Upper case letters are not allowed.
+		NewHeader("X-Bar", "baz", false),
+		NewHeader("x-baz", "qux", false),
+	})
+
+	dec := NewDecoder()
+	dec.SetValidator(NewValidator(Request))
+
+	n, err := dec.Write(encoded.Bytes())
+
+	var merr *MalformedError
+
+	if n != encoded.Len() || !errors.As(err, &merr) {
+		t.Errorf("dec.Write(...) = %v, %v, want %v, *MalformedError",
+			n, err, encoded.Len())
+	}
+
+	if dec.ht.tablelen != 3 {
+		t.Errorf("dec.ht.tablelen = %v, want %v", dec.ht.tablelen, 3)
+	}
+}